@@ -3,6 +3,7 @@
 package cgroups
 
 import (
+	"fmt"
 	"sixDocker/cgroups/subsystems"
 
 	"github.com/sirupsen/logrus"
@@ -23,7 +24,9 @@ func NewCgroupManager(path string) *CgroupManager {
 // 在三个控制器中设置分别创建一个cgroup
 func (cgroupMgr *CgroupManager) Set(res *subsystems.ResourceConfig) error {
 	for _, subSysIns := range subsystems.SubsystemsIns {
-		subSysIns.Set(cgroupMgr.Path, res)
+		if err := subSysIns.Set(cgroupMgr.Path, res); err != nil {
+			logrus.Warnf("cgroup %s set err %v", subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
@@ -31,7 +34,9 @@ func (cgroupMgr *CgroupManager) Set(res *subsystems.ResourceConfig) error {
 // 将进程加入三个控制器中创建的cgroup
 func (cgroupMgr *CgroupManager) Apply(pid int) error {
 	for _, subSysIns := range subsystems.SubsystemsIns {
-		subSysIns.Apply(cgroupMgr.Path, pid)
+		if err := subSysIns.Apply(cgroupMgr.Path, pid); err != nil {
+			logrus.Warnf("cgroup %s apply err %v", subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
@@ -45,3 +50,47 @@ func (cgroupMgr *CgroupManager) Destroy() error {
 	}
 	return nil
 }
+
+// 判断 cgroup 中是否发生过 OOM kill，需要在 Destroy 之前调用
+func (cgroupMgr *CgroupManager) OOMKilled() bool {
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if reporter, ok := subSysIns.(subsystems.OOMReporter); ok {
+			killed, err := reporter.OOMKilled(cgroupMgr.Path)
+			if err != nil {
+				logrus.Warnf("cgroup %s read oom events err %v", subSysIns.Name(), err)
+				continue
+			}
+			if killed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 冻结 cgroup 中的所有进程
+func (cgroupMgr *CgroupManager) Freeze() error {
+	freezer, err := cgroupMgr.freezer()
+	if err != nil {
+		return err
+	}
+	return freezer.Freeze(cgroupMgr.Path)
+}
+
+// 解冻 cgroup 中的所有进程
+func (cgroupMgr *CgroupManager) Thaw() error {
+	freezer, err := cgroupMgr.freezer()
+	if err != nil {
+		return err
+	}
+	return freezer.Thaw(cgroupMgr.Path)
+}
+
+func (cgroupMgr *CgroupManager) freezer() (subsystems.Freezer, error) {
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if freezer, ok := subSysIns.(subsystems.Freezer); ok {
+			return freezer, nil
+		}
+	}
+	return nil, fmt.Errorf("freezer subsystem is not available")
+}
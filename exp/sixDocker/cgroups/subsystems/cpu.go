@@ -3,6 +3,7 @@
 package subsystems
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -29,6 +30,19 @@ func (s *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 				return fmt.Errorf("set cgroup cpu.shares fail %v", err)
 			}
 		}
+		// 如果ResourceConfig中配置了 CpuQuota 则通过 cfs 配额限制 cpu 使用量
+		if res.CpuQuota != "" {
+			quota, period, err := parseCpuQuota(res.CpuQuota)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_period_us"), []byte(strconv.FormatInt(period, 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu.cfs_period_us fail %v", err)
+			}
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_quota_us"), []byte(strconv.FormatInt(quota, 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu.cfs_quota_us fail %v", err)
+			}
+		}
 		return nil
 	} else {
 		return err
@@ -58,6 +72,9 @@ func (s *CpuSubSystem) Remove(cgroupPath string) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 		// 删除该 cgroup 目录
 		return os.RemoveAll(subsysCgroupPath)
+	} else if errors.Is(err, os.ErrNotExist) {
+		// cgroup 已经被删除(例如 stop 之后再 rm)，无需处理
+		return nil
 	} else {
 		return err
 	}
@@ -0,0 +1,72 @@
+// exp/sixDocker/cgroups/subsystems/cpu_v2.go
+
+package subsystems
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+// cgroup v2 下的 cpu controller
+type CpuSubSystemV2 struct{}
+
+func (s *CpuSubSystemV2) Name() string {
+	return "cpu"
+}
+
+// v2 没有 cpu.shares，使用 cpu.weight 表示相对权重，使用 cpu.max 表示配额
+func (s *CpuSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetCgroupV2Path(s.Name(), cgroupPath, true); err == nil {
+		if res.CpuShare != "" {
+			shares, err := strconv.ParseUint(res.CpuShare, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid cpu share %s", res.CpuShare)
+			}
+			weight := strconv.FormatUint(cpuSharesToWeight(shares), 10)
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.weight"), []byte(weight), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu.weight fail %v", err)
+			}
+		}
+		if res.CpuQuota != "" {
+			quota, period, err := parseCpuQuota(res.CpuQuota)
+			if err != nil {
+				return err
+			}
+			// cpu.max 格式: "$MAX $PERIOD"
+			cpuMax := fmt.Sprintf("%d %d", quota, period)
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.max"), []byte(cpuMax), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu.max fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+// v2 中所有 controller 共用同一个 cgroup 目录，进程通过 cgroup.procs 加入
+func (s *CpuSubSystemV2) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupV2Path(s.Name(), cgroupPath, true); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu procs fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+// 多个 controller 共用同一个目录，已被其他 controller 删除时直接返回
+func (s *CpuSubSystemV2) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupV2Path(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else {
+		return err
+	}
+}
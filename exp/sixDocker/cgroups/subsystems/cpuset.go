@@ -3,11 +3,13 @@
 package subsystems
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 type CpusetSubSystem struct{}
@@ -34,6 +36,13 @@ func (s *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 
 func (s *CpusetSubSystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		// 新建的 cpuset cgroup 中 cpuset.cpus 和 cpuset.mems 为空，此时加入进程会返回 ENOSPC
+		// 因此未配置时继承父 cgroup 的值
+		for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+			if err := inheritCpusetFile(subsysCgroupPath, file); err != nil {
+				return err
+			}
+		}
 		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf("set cgroup cpuset tasks fail %v", err)
 		}
@@ -46,7 +55,27 @@ func (s *CpusetSubSystem) Apply(cgroupPath string, pid int) error {
 func (s *CpusetSubSystem) Remove(cgroupPath string) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 		return os.RemoveAll(subsysCgroupPath)
+	} else if errors.Is(err, os.ErrNotExist) {
+		return nil
 	} else {
 		return err
 	}
 }
+
+func inheritCpusetFile(subsysCgroupPath string, file string) error {
+	content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, file))
+	if err != nil {
+		return fmt.Errorf("read cgroup %s fail %v", file, err)
+	}
+	if strings.TrimSpace(string(content)) != "" {
+		return nil
+	}
+	parent, err := ioutil.ReadFile(path.Join(path.Dir(subsysCgroupPath), file))
+	if err != nil {
+		return fmt.Errorf("read parent cgroup %s fail %v", file, err)
+	}
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, file), parent, 0644); err != nil {
+		return fmt.Errorf("set cgroup %s fail %v", file, err)
+	}
+	return nil
+}
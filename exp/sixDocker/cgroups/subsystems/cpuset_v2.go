@@ -0,0 +1,52 @@
+// exp/sixDocker/cgroups/subsystems/cpuset_v2.go
+
+package subsystems
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+type CpusetSubSystemV2 struct{}
+
+func (s *CpusetSubSystemV2) Name() string {
+	return "cpuset"
+}
+
+func (s *CpusetSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetCgroupV2Path(s.Name(), cgroupPath, true); err == nil {
+		if res.CpuSet != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpuset.cpus fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *CpusetSubSystemV2) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupV2Path(s.Name(), cgroupPath, true); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpuset procs fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *CpusetSubSystemV2) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupV2Path(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else {
+		return err
+	}
+}
@@ -0,0 +1,91 @@
+// exp/sixDocker/cgroups/subsystems/freezer.go
+
+package subsystems
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type FreezerSubSystem struct{}
+
+func (s *FreezerSubSystem) Name() string {
+	return "freezer"
+}
+
+// freezer 没有资源限制需要设置
+func (s *FreezerSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	return nil
+}
+
+func (s *FreezerSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup freezer tasks fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *FreezerSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *FreezerSubSystem) Freeze(cgroupPath string) error {
+	return s.setState(cgroupPath, "FROZEN")
+}
+
+func (s *FreezerSubSystem) Thaw(cgroupPath string) error {
+	return s.setState(cgroupPath, "THAWED")
+}
+
+// 写入 freezer.state 后内核可能先处于 FREEZING 中间状态，需要等待状态真正变为目标值
+func (s *FreezerSubSystem) setState(cgroupPath string, state string) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	stateFile := path.Join(subsysCgroupPath, "freezer.state")
+	if err := ioutil.WriteFile(stateFile, []byte(state), 0644); err != nil {
+		return fmt.Errorf("set cgroup freezer.state fail %v", err)
+	}
+	return waitFreezerState(func() (bool, error) {
+		content, err := ioutil.ReadFile(stateFile)
+		if err != nil {
+			return false, err
+		}
+		return strings.TrimSpace(string(content)) == state, nil
+	})
+}
+
+// 每 10ms 检查一次冻结/解冻是否完成，最多等待 5 秒
+func waitFreezerState(done func() (bool, error)) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ok, err := done()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for cgroup freezer state")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,77 @@
+// exp/sixDocker/cgroups/subsystems/freezer_v2.go
+
+package subsystems
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// v2 没有 freezer controller，cgroup.freeze 是每个非根 cgroup 都有的核心接口文件
+type FreezerSubSystemV2 struct{}
+
+func (s *FreezerSubSystemV2) Name() string {
+	return "freezer"
+}
+
+func (s *FreezerSubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	return nil
+}
+
+func (s *FreezerSubSystemV2) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupV2Path("", cgroupPath, true); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup freezer procs fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *FreezerSubSystemV2) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupV2Path("", cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *FreezerSubSystemV2) Freeze(cgroupPath string) error {
+	return s.setFrozen(cgroupPath, "1")
+}
+
+func (s *FreezerSubSystemV2) Thaw(cgroupPath string) error {
+	return s.setFrozen(cgroupPath, "0")
+}
+
+// 写入 cgroup.freeze 后，等待 cgroup.events 中的 frozen 字段变为目标值
+func (s *FreezerSubSystemV2) setFrozen(cgroupPath string, frozen string) error {
+	subsysCgroupPath, err := GetCgroupV2Path("", cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.freeze"), []byte(frozen), 0644); err != nil {
+		return fmt.Errorf("set cgroup.freeze fail %v", err)
+	}
+	return waitFreezerState(func() (bool, error) {
+		content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, "cgroup.events"))
+		if err != nil {
+			return false, err
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "frozen" {
+				return fields[1] == frozen, nil
+			}
+		}
+		return false, nil
+	})
+}
@@ -3,6 +3,7 @@
 package subsystems
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -43,7 +44,22 @@ func (s *MemorySubSystem) Apply(cgroupPath string, pid int) error {
 func (s *MemorySubSystem) Remove(cgroupPath string) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 		return os.RemoveAll(subsysCgroupPath)
+	} else if errors.Is(err, os.ErrNotExist) {
+		return nil
 	} else {
 		return err
 	}
 }
+
+// 容器进程退出后、删除 cgroup 之前调用，oom_kill 计数大于 0 说明有进程被 OOM killer 杀死
+func (s *MemorySubSystem) OOMKilled(cgroupPath string) (bool, error) {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return false, err
+	}
+	count, err := readOOMKillCount(path.Join(subsysCgroupPath, "memory.oom_control"))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,66 @@
+// exp/sixDocker/cgroups/subsystems/memory_v2.go
+
+package subsystems
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+type MemorySubSystemV2 struct{}
+
+func (s *MemorySubSystemV2) Name() string {
+	return "memory"
+}
+
+// memory.max 与 v1 的 memory.limit_in_bytes 一样支持 k/m/g 后缀
+func (s *MemorySubSystemV2) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetCgroupV2Path(s.Name(), cgroupPath, true); err == nil {
+		if res.MemoryLimit != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.max"), []byte(res.MemoryLimit), 0644); err != nil {
+				return fmt.Errorf("set cgroup memory.max fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *MemorySubSystemV2) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupV2Path(s.Name(), cgroupPath, true); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory procs fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *MemorySubSystemV2) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupV2Path(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else {
+		return err
+	}
+}
+
+// 容器进程退出后、删除 cgroup 之前调用，oom_kill 计数大于 0 说明有进程被 OOM killer 杀死
+func (s *MemorySubSystemV2) OOMKilled(cgroupPath string) (bool, error) {
+	subsysCgroupPath, err := GetCgroupV2Path(s.Name(), cgroupPath, false)
+	if err != nil {
+		return false, err
+	}
+	count, err := readOOMKillCount(path.Join(subsysCgroupPath, "memory.events"))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
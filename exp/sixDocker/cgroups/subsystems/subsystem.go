@@ -7,6 +7,7 @@ type ResourceConfig struct {
 	MemoryLimit string
 	CpuShare    string
 	CpuSet      string
+	CpuQuota    string // 可使用的 cpu 核数，例如 1.5
 }
 
 // 接口放函数签名
@@ -23,10 +24,36 @@ type Subsystem interface {
 
 var (
 	// SubsystemsIns 实例列表
-	SubsystemsIns = []Subsystem{
+	// 宿主机只挂载了 cgroup v2(unified hierarchy) 时使用 v2 实现，否则使用 v1 实现
+	SubsystemsIns = newSubsystemsIns()
+)
+
+func newSubsystemsIns() []Subsystem {
+	if IsCgroup2UnifiedMode() {
+		return []Subsystem{
+			&CpuSubSystemV2{},
+			&CpusetSubSystemV2{},
+			&MemorySubSystemV2{},
+			&FreezerSubSystemV2{},
+		}
+	}
+	return []Subsystem{
 		// 结构体实例指针 type=Subsystem
 		&CpuSubSystem{},
 		&CpusetSubSystem{},
 		&MemorySubSystem{},
+		&FreezerSubSystem{},
 	}
-)
+}
+
+// 能够报告 OOM kill 事件的子系统(memory)
+type OOMReporter interface {
+	// 返回 cgroup 中是否有进程因为内存超限被 OOM killer 杀死
+	OOMKilled(path string) (bool, error)
+}
+
+// 能够冻结/解冻 cgroup 中所有进程的子系统(freezer)
+type Freezer interface {
+	Freeze(path string) error
+	Thaw(path string) error
+}
@@ -5,8 +5,10 @@ package subsystems
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -48,6 +50,32 @@ func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string
 		}
 		return path.Join(cgroupRoot, cgroupPath), nil
 	} else {
-		return "", fmt.Errorf("cgroup path error %v", err)
+		return "", fmt.Errorf("cgroup path error %w", err)
 	}
 }
+
+// 将 cpu 核数(例如 1.5)换算为 cfs 配额和周期(单位: 微秒)
+func parseCpuQuota(cpus string) (int64, int64, error) {
+	const period int64 = 100000
+	value, err := strconv.ParseFloat(cpus, 64)
+	if err != nil || value <= 0 {
+		return 0, 0, fmt.Errorf("invalid cpu quota %s", cpus)
+	}
+	return int64(value * float64(period)), period, nil
+}
+
+// 从 memory.oom_control(v1) 或 memory.events(v2) 中读取 oom_kill 计数
+// 文件格式为每行一个 "key value"
+func readOOMKillCount(file string) (uint64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,208 @@
+// exp/sixDocker/cgroups/subsystems/utils_v2.go
+
+package subsystems
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+const (
+	cgroupMountRoot = "/sys/fs/cgroup"
+	// statfs 返回的 cgroup2 文件系统类型
+	cgroup2SuperMagic = 0x63677270
+)
+
+// 测试中替换为伪造的 mountinfo
+var mountInfoPath = "/proc/self/mountinfo"
+
+// 判断宿主机是否只挂载了 cgroup v2(unified hierarchy)
+// 混合模式(/sys/fs/cgroup 为 tmpfs，v2 挂载在 /sys/fs/cgroup/unified)下控制器仍在 v1 上，按 v1 处理
+func IsCgroup2UnifiedMode() bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(cgroupMountRoot, &st); err != nil {
+		return false
+	}
+	return st.Type == cgroup2SuperMagic
+}
+
+// 查找 cgroup2 文件系统的挂载点
+func FindCgroupV2Mountpoint() string {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// [mount ID, parent mount ID, major:minor, root, mount point, options..., optional fields, -, fs type, mount source, super options]
+		fields := strings.Split(scanner.Text(), " ")
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) && fields[i+1] == "cgroup2" {
+				return fields[4]
+			}
+		}
+	}
+	return ""
+}
+
+// 获取 v2 下 cgroup 的绝对路径
+// autoCreate 为 true 时会先在各级父 cgroup 的 cgroup.subtree_control 中启用 controller，再创建该 cgroup
+// controller 为空时只使用 cgroup 的核心接口文件(例如 cgroup.freeze)，不需要启用 controller
+func GetCgroupV2Path(controller string, cgroupPath string, autoCreate bool) (string, error) {
+	cgroupRoot := FindCgroupV2Mountpoint()
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("cgroup2 mount point not found")
+	}
+	fullPath := path.Join(cgroupRoot, cgroupPath)
+	if _, err := os.Stat(fullPath); err == nil || (autoCreate && os.IsNotExist(err)) {
+		if autoCreate && controller != "" {
+			if err := enableController(cgroupRoot, cgroupPath, controller); err != nil {
+				return "", err
+			}
+		}
+		if os.IsNotExist(err) {
+			if err := os.MkdirAll(fullPath, 0755); err != nil {
+				return "", fmt.Errorf("error create cgroup %v", err)
+			}
+		}
+		return fullPath, nil
+	} else {
+		return "", fmt.Errorf("cgroup path error %w", err)
+	}
+}
+
+// v2 中子 cgroup 能否使用某个 controller 由父 cgroup 的 cgroup.subtree_control 决定
+// 因此需要从根 cgroup 开始，逐级在 cgroupPath 的所有祖先中启用该 controller
+func enableController(cgroupRoot string, cgroupPath string, controller string) error {
+	current := cgroupRoot
+	parts := strings.Split(strings.Trim(path.Clean("/"+cgroupPath), "/"), "/")
+	for _, part := range parts {
+		if part == "" {
+			break
+		}
+		if err := os.MkdirAll(current, 0755); err != nil {
+			return fmt.Errorf("error create cgroup %v", err)
+		}
+		controllers, err := ioutil.ReadFile(path.Join(current, "cgroup.controllers"))
+		if err != nil {
+			return fmt.Errorf("read %s cgroup.controllers fail %v", current, err)
+		}
+		if !containsField(string(controllers), controller) {
+			return fmt.Errorf("controller %s is not available in %s", controller, current)
+		}
+		subtreeControl, err := ioutil.ReadFile(path.Join(current, "cgroup.subtree_control"))
+		if err != nil {
+			return fmt.Errorf("read %s cgroup.subtree_control fail %v", current, err)
+		}
+		if !containsField(string(subtreeControl), controller) {
+			// 委派边界之外的祖先 cgroup 不属于当前用户，只能由其属主(例如 systemd)启用 controller
+			if !ownedByCurrentUser(current) {
+				return fmt.Errorf("controller %s is not delegated in %s", controller, current)
+			}
+			if err := writeSubtreeControl(current, "+"+controller); err != nil {
+				return fmt.Errorf("enable controller %s in %s fail %v", controller, current, err)
+			}
+		}
+		current = path.Join(current, part)
+	}
+	return nil
+}
+
+// cgroup.subtree_control 每次写入只修改写入的 controller，以追加方式打开，不截断文件
+func writeSubtreeControl(dir string, change string) error {
+	f, err := os.OpenFile(path.Join(dir, "cgroup.subtree_control"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(change + "\n")
+	return err
+}
+
+// cgroup 目录的属主是当前用户时，才认为当前用户可以修改其中的 cgroup.subtree_control
+func ownedByCurrentUser(dir string) bool {
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return false
+	}
+	return int(st.Uid) == os.Geteuid()
+}
+
+func containsField(content string, field string) bool {
+	for _, f := range strings.Fields(content) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// 将 v1 的 cpu.shares(2~262144，默认 1024) 换算为 v2 的 cpu.weight(1~10000，默认 100)
+func cpuSharesToWeight(shares uint64) uint64 {
+	if shares == 0 {
+		return 0
+	}
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// 查找委派(delegate)给当前用户的 cgroup v2 子树，返回相对于 cgroup 根目录的路径
+// 例如 systemd 把 user@<uid>.service 委派给用户，普通用户只能在其中创建 cgroup 和移动进程
+// 从当前进程所在的 cgroup 开始向上查找，返回属于当前用户并且可以修改的最上层 cgroup
+func DelegatedCgroupV2Path() (string, error) {
+	if !IsCgroup2UnifiedMode() {
+		return "", fmt.Errorf("cgroup v2 is not enabled")
+	}
+	cgroupRoot := FindCgroupV2Mountpoint()
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("cgroup2 mount point not found")
+	}
+	return DelegatedCgroupV2PathIn(cgroupRoot, "/proc/self/cgroup")
+}
+
+// 根据 procCgroupFile(格式同 /proc/self/cgroup)中记录的 cgroup，在挂载于 cgroupRoot 的 cgroup v2 中查找委派的子树
+func DelegatedCgroupV2PathIn(cgroupRoot string, procCgroupFile string) (string, error) {
+	content, err := ioutil.ReadFile(procCgroupFile)
+	if err != nil {
+		return "", err
+	}
+	// v2 下 /proc/self/cgroup 只有一行: 0::<cgroup 路径>
+	current := ""
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			current = path.Clean(strings.TrimPrefix(line, "0::"))
+		}
+	}
+	if current == "" {
+		return "", fmt.Errorf("cgroup of current process not found")
+	}
+
+	delegated := ""
+	for p := current; p != "/" && p != "."; p = path.Dir(p) {
+		if !ownedByCurrentUser(path.Join(cgroupRoot, p)) {
+			break
+		}
+		// 2 为 W_OK，需要能够启用 controller 和移动进程
+		if syscall.Access(path.Join(cgroupRoot, p, "cgroup.subtree_control"), 2) != nil ||
+			syscall.Access(path.Join(cgroupRoot, p, "cgroup.procs"), 2) != nil {
+			break
+		}
+		delegated = p
+	}
+	if delegated == "" {
+		return "", fmt.Errorf("no cgroup is delegated to uid %d in %s", os.Geteuid(), current)
+	}
+	return delegated, nil
+}
@@ -0,0 +1,182 @@
+// exp/sixDocker/cgroups/subsystems/utils_v2_test.go
+
+package subsystems
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCgroupV2Mountpoint(t *testing.T) {
+	oldMountInfo := mountInfoPath
+	defer func() { mountInfoPath = oldMountInfo }()
+	mountInfoPath = path.Join(t.TempDir(), "mountinfo")
+
+	cases := []struct {
+		mountinfo string
+		want      string
+	}{
+		// unified 模式
+		{"22 1 0:21 / /sys/fs/cgroup rw,nosuid shared:4 - cgroup2 cgroup2 rw,nsdelegate\n", "/sys/fs/cgroup"},
+		// 混合模式，v2 挂载在 unified 下
+		{"25 24 0:22 / /sys/fs/cgroup rw shared:5 - tmpfs tmpfs ro,mode=755\n" +
+			"26 25 0:23 / /sys/fs/cgroup/unified rw shared:6 - cgroup2 cgroup2 rw\n" +
+			"27 25 0:24 / /sys/fs/cgroup/cpu,cpuacct rw shared:7 - cgroup cgroup rw,cpu,cpuacct\n", "/sys/fs/cgroup/unified"},
+		// 只有 v1
+		{"27 25 0:24 / /sys/fs/cgroup/memory rw shared:7 - cgroup cgroup rw,memory\n", ""},
+	}
+	for _, c := range cases {
+		if err := os.WriteFile(mountInfoPath, []byte(c.mountinfo), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := FindCgroupV2Mountpoint(); got != c.want {
+			t.Errorf("FindCgroupV2Mountpoint = %q, want %q", got, c.want)
+		}
+	}
+
+	// unified 模式下一定能找到 cgroup2 的挂载点
+	mountInfoPath = oldMountInfo
+	if IsCgroup2UnifiedMode() && FindCgroupV2Mountpoint() == "" {
+		t.Errorf("cgroup v2 unified mode but no cgroup2 mount point found")
+	}
+}
+
+// 在临时目录中伪造 cgroup 层级，每一级都可以使用 cpu、memory、io
+func fakeCgroupV2Hierarchy(t *testing.T, dirs ...string) string {
+	root := t.TempDir()
+	for _, dir := range append([]string{""}, dirs...) {
+		full := path.Join(root, dir)
+		if err := os.MkdirAll(full, 0755); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(path.Join(full, "cgroup.controllers"), []byte("cpu io memory\n"), 0644)
+		os.WriteFile(path.Join(full, "cgroup.subtree_control"), nil, 0644)
+	}
+	return root
+}
+
+func TestEnableController(t *testing.T) {
+	root := fakeCgroupV2Hierarchy(t, "sixDocker")
+	for _, controller := range []string{"cpu", "memory"} {
+		if err := enableController(root, "sixDocker/abc", controller); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 根 cgroup 和 sixDocker 是 sixDocker/abc 的祖先，都需要启用；abc 本身由调用者创建
+	for _, dir := range []string{"", "sixDocker"} {
+		content, err := os.ReadFile(path.Join(root, dir, "cgroup.subtree_control"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.Fields(string(content)), []string{"+cpu", "+memory"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s/cgroup.subtree_control = %q, want %q", dir, got, want)
+		}
+	}
+	if _, err := os.Stat(path.Join(root, "sixDocker/abc")); !os.IsNotExist(err) {
+		t.Errorf("enableController should not create the leaf cgroup")
+	}
+
+	// 已经启用的 controller 不会重复写入
+	os.WriteFile(path.Join(root, "cgroup.subtree_control"), []byte("cpu memory\n"), 0644)
+	if err := enableController(root, "sixDocker/abc", "cpu"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(path.Join(root, "cgroup.subtree_control")); string(content) != "cpu memory\n" {
+		t.Errorf("cgroup.subtree_control rewritten: %q", content)
+	}
+
+	// 祖先中没有的 controller 报错
+	if err := enableController(root, "sixDocker/abc", "pids"); err == nil {
+		t.Errorf("expected error for unavailable controller")
+	}
+}
+
+func TestCpuSharesToWeight(t *testing.T) {
+	cases := map[uint64]uint64{
+		0:       0,
+		1:       1,
+		2:       1,
+		1024:    39,
+		262144:  10000,
+		1000000: 10000,
+	}
+	for shares, weight := range cases {
+		if got := cpuSharesToWeight(shares); got != weight {
+			t.Fatalf("cpuSharesToWeight(%d) = %d, want %d", shares, got, weight)
+		}
+	}
+}
+
+// 委派边界之外的祖先 cgroup 属于其他用户，只检查 controller 是否已经启用，不写入
+func TestEnableControllerDelegationBoundary(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown requires root")
+	}
+	root := fakeCgroupV2Hierarchy(t, "user.slice")
+	if err := os.Chown(root, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if err := enableController(root, "user.slice/abc", "cpu"); err == nil {
+		t.Errorf("expected error when controller is not delegated")
+	}
+	if content, _ := os.ReadFile(path.Join(root, "cgroup.subtree_control")); len(content) != 0 {
+		t.Errorf("cgroup.subtree_control outside the delegation boundary written: %q", content)
+	}
+
+	os.WriteFile(path.Join(root, "cgroup.subtree_control"), []byte("cpu\n"), 0644)
+	if err := enableController(root, "user.slice/abc", "cpu"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(path.Join(root, "user.slice", "cgroup.subtree_control")); strings.TrimSpace(string(content)) != "+cpu" {
+		t.Errorf("user.slice/cgroup.subtree_control = %q, want +cpu", content)
+	}
+}
+
+// 伪造 systemd 的用户 cgroup 层级: user@1000.service 及以下委派给当前用户，上层 slice 不可修改
+func fakeDelegatedCgroupV2Hierarchy(t *testing.T) (string, string) {
+	leaf := "user.slice/user-1000.slice/user@1000.service/app.slice/shell.scope"
+	var dirs []string
+	for p := leaf; p != "."; p = path.Dir(p) {
+		dirs = append(dirs, p)
+	}
+	root := fakeCgroupV2Hierarchy(t, dirs...)
+	for _, p := range dirs {
+		if strings.HasPrefix(p, "user.slice/user-1000.slice/user@1000.service") {
+			os.WriteFile(path.Join(root, p, "cgroup.procs"), nil, 0644)
+		} else {
+			os.Remove(path.Join(root, p, "cgroup.subtree_control"))
+		}
+	}
+	os.Remove(path.Join(root, "cgroup.subtree_control"))
+	procCgroup := path.Join(t.TempDir(), "cgroup")
+	if err := os.WriteFile(procCgroup, []byte("0::/"+leaf+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root, procCgroup
+}
+
+func TestDelegatedCgroupV2Path(t *testing.T) {
+	root, procCgroup := fakeDelegatedCgroupV2Hierarchy(t)
+	delegated, err := DelegatedCgroupV2PathIn(root, procCgroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/user.slice/user-1000.slice/user@1000.service"; delegated != want {
+		t.Errorf("DelegatedCgroupV2PathIn = %q, want %q", delegated, want)
+	}
+
+	// 当前 cgroup 不可修改时没有委派的子树
+	os.WriteFile(procCgroup, []byte("0::/user.slice/user-1000.slice\n"), 0644)
+	if delegated, err := DelegatedCgroupV2PathIn(root, procCgroup); err == nil {
+		t.Errorf("expected error, got delegated cgroup %q", delegated)
+	}
+
+	// 只有 v1 的 /proc/self/cgroup 中没有 0:: 行
+	os.WriteFile(procCgroup, []byte("4:memory:/user.slice\n"), 0644)
+	if _, err := DelegatedCgroupV2PathIn(root, procCgroup); err == nil {
+		t.Errorf("expected error without cgroup v2 entry")
+	}
+}
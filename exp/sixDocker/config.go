@@ -0,0 +1,92 @@
+// exp/sixDocker/config.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sixDocker/container"
+	"sixDocker/network"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	// 通过环境变量指定状态根目录
+	rootEnv = "SIXDOCKER_ROOT"
+	// 默认的配置文件，不存在时忽略
+	defaultConfigFile = "/etc/sixDocker/config.json"
+)
+
+// 配置文件的内容，例如 {"root": "/data/sixDocker"}
+type daemonConfig struct {
+	Root string `json:"root"`
+}
+
+var globalFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "root",
+		Usage:  "root directory of sixDocker state (containers, images, layers, network)",
+		EnvVar: rootEnv,
+	},
+	cli.StringFlag{
+		Name:  "config",
+		Usage: "location of the config file",
+		Value: defaultConfigFile,
+	},
+}
+
+// 读取配置文件，required 为 false 时配置文件不存在不报错
+func loadConfig(configFile string, required bool) (*daemonConfig, error) {
+	config := &daemonConfig{}
+	content, err := os.ReadFile(configFile)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return config, nil
+		}
+		return nil, fmt.Errorf("read config file %s error: %v", configFile, err)
+	}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("parse config file %s error: %v", configFile, err)
+	}
+	return config, nil
+}
+
+// 确定状态根目录，优先级: --root > SIXDOCKER_ROOT > 配置文件 > 默认值
+func setupRoot(context *cli.Context) error {
+	configFile := context.String("config")
+	if container.Rootless && !context.IsSet("config") {
+		configFile = rootlessDefaultConfigFile()
+	}
+	config, err := loadConfig(configFile, context.IsSet("config"))
+	if err != nil {
+		return err
+	}
+	root := config.Root
+	if context.IsSet("root") {
+		root = context.String("root")
+	}
+	if root == "" {
+		if root, err = defaultRoot(); err != nil {
+			return err
+		}
+	}
+	// monitor 等子进程会在其他工作目录下运行，统一使用绝对路径
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	container.SetRoot(root)
+	network.SetRoot(root)
+	return nil
+}
+
+// 默认的状态根目录，rootless 模式下位于 $XDG_RUNTIME_DIR 下
+func defaultRoot() (string, error) {
+	if container.Rootless {
+		return rootlessDefaultRoot()
+	}
+	return container.DefaultRoot, nil
+}
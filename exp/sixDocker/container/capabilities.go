@@ -0,0 +1,211 @@
+// exp/sixDocker/container/capabilities.go
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// capability 名称与编号，编号见 linux/capability.h
+var capabilityNumbers = map[string]int{
+	"CAP_CHOWN":              0,
+	"CAP_DAC_OVERRIDE":       1,
+	"CAP_DAC_READ_SEARCH":    2,
+	"CAP_FOWNER":             3,
+	"CAP_FSETID":             4,
+	"CAP_KILL":               5,
+	"CAP_SETGID":             6,
+	"CAP_SETUID":             7,
+	"CAP_SETPCAP":            8,
+	"CAP_LINUX_IMMUTABLE":    9,
+	"CAP_NET_BIND_SERVICE":   10,
+	"CAP_NET_BROADCAST":      11,
+	"CAP_NET_ADMIN":          12,
+	"CAP_NET_RAW":            13,
+	"CAP_IPC_LOCK":           14,
+	"CAP_IPC_OWNER":          15,
+	"CAP_SYS_MODULE":         16,
+	"CAP_SYS_RAWIO":          17,
+	"CAP_SYS_CHROOT":         18,
+	"CAP_SYS_PTRACE":         19,
+	"CAP_SYS_PACCT":          20,
+	"CAP_SYS_ADMIN":          21,
+	"CAP_SYS_BOOT":           22,
+	"CAP_SYS_NICE":           23,
+	"CAP_SYS_RESOURCE":       24,
+	"CAP_SYS_TIME":           25,
+	"CAP_SYS_TTY_CONFIG":     26,
+	"CAP_MKNOD":              27,
+	"CAP_LEASE":              28,
+	"CAP_AUDIT_WRITE":        29,
+	"CAP_AUDIT_CONTROL":      30,
+	"CAP_SETFCAP":            31,
+	"CAP_MAC_OVERRIDE":       32,
+	"CAP_MAC_ADMIN":          33,
+	"CAP_SYSLOG":             34,
+	"CAP_WAKE_ALARM":         35,
+	"CAP_BLOCK_SUSPEND":      36,
+	"CAP_AUDIT_READ":         37,
+	"CAP_PERFMON":            38,
+	"CAP_BPF":                39,
+	"CAP_CHECKPOINT_RESTORE": 40,
+}
+
+// 与 docker 相同的默认 capability 列表
+var DefaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// 统一为 CAP_XXX 的形式，ALL 保持不变
+func normalizeCapability(name string) (string, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "ALL" {
+		return name, nil
+	}
+	if !strings.HasPrefix(name, "CAP_") {
+		name = "CAP_" + name
+	}
+	if _, ok := capabilityNumbers[name]; !ok {
+		return "", fmt.Errorf("unknown capability %s", name)
+	}
+	return name, nil
+}
+
+// 根据 --cap-add 和 --cap-drop 计算容器的 capability 列表，规则与 docker 相同:
+// 以默认列表为基础，--cap-drop ALL 时从空列表开始，--cap-add ALL 时从全部 capability 开始，
+// 然后移除 --cap-drop 中的 capability，再加上 --cap-add 中的 capability
+func ParseCapabilities(capAdd []string, capDrop []string) ([]string, error) {
+	addAll, dropAll := false, false
+	var adds, drops []string
+	for _, name := range capAdd {
+		c, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		if c == "ALL" {
+			addAll = true
+			continue
+		}
+		adds = append(adds, c)
+	}
+	for _, name := range capDrop {
+		c, err := normalizeCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		if c == "ALL" {
+			dropAll = true
+			continue
+		}
+		drops = append(drops, c)
+	}
+
+	caps := map[string]bool{}
+	switch {
+	case addAll:
+		for c := range capabilityNumbers {
+			caps[c] = true
+		}
+	case !dropAll:
+		for _, c := range DefaultCapabilities {
+			caps[c] = true
+		}
+	}
+	for _, c := range drops {
+		delete(caps, c)
+	}
+	for _, c := range adds {
+		caps[c] = true
+	}
+
+	// 返回非 nil 的切片，保存后与没有记录 capability 的旧容器区分开
+	result := []string{}
+	for c := range caps {
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return capabilityNumbers[result[i]] < capabilityNumbers[result[j]]
+	})
+	return result, nil
+}
+
+// capability 列表对应的位图
+func CapabilityMask(caps []string) uint64 {
+	var mask uint64
+	for _, c := range caps {
+		if n, ok := capabilityNumbers[c]; ok {
+			mask |= 1 << uint(n)
+		}
+	}
+	return mask
+}
+
+// 当前内核支持的最大 capability 编号
+func lastCapability() int {
+	content, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return capabilityNumbers["CAP_CHECKPOINT_RESTORE"]
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return capabilityNumbers["CAP_CHECKPOINT_RESTORE"]
+	}
+	return last
+}
+
+// 把当前进程的 bounding、effective、permitted 集合都限制为 caps，清空 inheritable 和 ambient 集合
+// inheritable 不为空时，用户命令执行带有 inheritable 文件 capability 的程序可以重新获得 capability(CVE-2022-24769)
+// capability 是线程的属性，调用后当前 goroutine 固定在该线程上，之后的 exec 也必须在同一个线程中执行
+func applyCapabilities(caps []string) error {
+	runtime.LockOSThread()
+
+	last := lastCapability()
+	mask := CapabilityMask(caps)
+	if last < 63 {
+		mask &= (uint64(1) << uint(last+1)) - 1
+	}
+	// 先缩小 bounding 集合，需要 CAP_SETPCAP，所以在 capset 之前完成
+	for c := 0; c <= last; c++ {
+		if mask&(1<<uint(c)) != 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+			return fmt.Errorf("drop capability %d from bounding set error: %v", c, err)
+		}
+	}
+	// 老内核不支持 ambient 集合，忽略 EINVAL
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil && err != unix.EINVAL {
+		return fmt.Errorf("clear ambient capabilities error: %v", err)
+	}
+
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	for i := range data {
+		set := uint32(mask >> (32 * uint(i)))
+		data[i] = unix.CapUserData{Effective: set, Permitted: set}
+	}
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("capset error: %v", err)
+	}
+	return nil
+}
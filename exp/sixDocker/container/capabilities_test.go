@@ -0,0 +1,44 @@
+// exp/sixDocker/container/capabilities_test.go
+
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	caps, err := ParseCapabilities(nil, nil)
+	if err != nil || len(caps) != len(DefaultCapabilities) {
+		t.Fatalf("default capabilities = %v, %v", caps, err)
+	}
+	if mask := CapabilityMask(caps); mask != 0xa80425fb {
+		t.Errorf("default capability mask = %x, want a80425fb", mask)
+	}
+
+	caps, err = ParseCapabilities([]string{"net_admin"}, []string{"CAP_CHOWN", "mknod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mask := CapabilityMask(caps)
+	if mask&(1<<12) == 0 || mask&1 != 0 || mask&(1<<27) != 0 {
+		t.Errorf("capabilities = %v", caps)
+	}
+
+	caps, err = ParseCapabilities([]string{"SYS_ADMIN"}, []string{"ALL"})
+	if err != nil || !reflect.DeepEqual(caps, []string{"CAP_SYS_ADMIN"}) {
+		t.Errorf("capabilities = %v, %v", caps, err)
+	}
+	caps, err = ParseCapabilities(nil, []string{"all"})
+	if err != nil || caps == nil || len(caps) != 0 {
+		t.Errorf("capabilities = %v, %v", caps, err)
+	}
+	caps, err = ParseCapabilities([]string{"ALL"}, []string{"SYS_MODULE"})
+	if err != nil || len(caps) != len(capabilityNumbers)-1 {
+		t.Errorf("capabilities = %v, %v", caps, err)
+	}
+
+	if _, err := ParseCapabilities([]string{"FOO"}, nil); err == nil {
+		t.Errorf("expected error for unknown capability")
+	}
+}
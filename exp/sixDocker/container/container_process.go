@@ -6,31 +6,43 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"os"
 	"os/exec"
 	"path"
+	"sixDocker/cgroups"
+	"sixDocker/cgroups/subsystems"
+	"sixDocker/fileutil"
+	"sixDocker/format"
+	"sixDocker/seccomp"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/olekukonko/tablewriter"
-
 	log "github.com/sirupsen/logrus"
 )
 
 var (
+	CREATED             string = "created"
 	RUNNING             string = "running"
+	RESTARTING          string = "restarting"
+	PAUSED              string = "paused"
 	STOPPED             string = "stopped"
 	EXIT                string = "exited"
 	IMAGEDIR            string = "/var/run/sixDocker/images"
 	READONLYLAYERDIR    string = "/var/run/sixDocker/readOnlyLayer"
-	DefaultInfoLocation string = "/workspace/projects/go/dockerDev/run/containers/%s"
+	DefaultInfoLocation string = "/var/run/sixDocker/containers/%s"
+	NameIndexLocation   string = "/var/run/sixDocker/names/%s"
 	ConfigName          string = "config.json"
+	ConfigLockName      string = "config.lock"
+	StartLockName       string = "start.lock"
 	ContainerLogFile    string = "container.log"
+	MonitorLogFile      string = "monitor.log"
 )
 
+// 容器信息中时间字段的格式
+const TimeFormat = "2006-01-02 15:04:05"
+
 type ContainerInfo struct {
 	Pid         string   `json:"pid"`         // 容器init进程的pid
 	Id          string   `json:"id"`          // 容器id
@@ -40,19 +52,59 @@ type ContainerInfo struct {
 	Status      string   `json:"status"`      // 容器状态
 	Volume      []string `json:"volume"`      // 容器挂载的卷
 	PortMapping []string `json:"portmapping"` // 容器端口映射
+	CgroupPath  string   `json:"cgroupPath"`  // 容器所属 cgroup 的相对路径
+
+	Image            string                     `json:"image"`            // 容器使用的镜像
+	Network          string                     `json:"network"`          // 容器连接的网络
+	Env              []string                   `json:"env"`              // 容器的环境变量
+	CommandArray     []string                   `json:"commandArray"`     // 容器内init进程要执行的命令(未拼接)
+	ResourceConfig   *subsystems.ResourceConfig `json:"resourceConfig"`   // 容器的资源限制
+	MonitorPid       string                     `json:"monitorPid"`       // 等待容器进程退出的进程(-ti 为 run 进程，-d 为 monitor 进程)
+	PidStartTime     uint64                     `json:"pidStartTime"`     // 容器init进程的启动时间，与 pid 一起判断进程是否还是原来的容器进程
+	MonitorStartTime uint64                     `json:"monitorStartTime"` // 等待容器进程退出的进程的启动时间
+	StartedAt        string                     `json:"startedAt"`        // 容器进程的启动时间
+	FinishedAt       string                     `json:"finishedAt"`       // 容器进程的退出时间
+	ExitCode         int                        `json:"exitCode"`         // 容器进程的退出码，被信号杀死时为 128 + 信号值
+	OOMKilled        bool                       `json:"oomKilled"`        // 容器进程是否因为内存超限被杀死
+	Error            string                     `json:"error"`            // 容器启动失败的原因
+
+	RestartPolicy   RestartPolicy     `json:"restartPolicy"`             // 容器的重启策略
+	RestartCount    int               `json:"restartCount"`              // 容器按照重启策略被重启的次数
+	ManuallyStopped bool              `json:"manuallyStopped"`           // 容器被 stop 停止，停止后不再按照重启策略重启
+	StopSignal      string            `json:"stopSignal"`                // stop 时发送给容器的信号，默认 SIGTERM
+	Init            bool              `json:"init"`                      // 由 init 进程作为 1 号进程转发信号、回收僵尸进程
+	AutoRemove      bool              `json:"autoRemove"`                // 容器退出后自动删除容器(--rm)
+	Labels          map[string]string `json:"labels,omitempty"`          // 容器的元数据
+	UidMappings     []IDMap           `json:"uidMappings,omitempty"`     // 启用 user namespace 时容器内 uid 到宿主机 uid 的映射
+	GidMappings     []IDMap           `json:"gidMappings,omitempty"`     // 启用 user namespace 时容器内 gid 到宿主机 gid 的映射
+	Capabilities    []string          `json:"capabilities"`              // 容器进程保留的 capability，为 null 时(旧版本创建的容器)不限制
+	SeccompProfile  *seccomp.Profile  `json:"seccompProfile,omitempty"`  // 容器进程的 seccomp 配置，为空时不限制系统调用
+	NoNewPrivileges bool              `json:"noNewPrivileges,omitempty"` // 设置 no_new_privs，禁止通过 setuid 程序等获得更多权限
+	ReadOnly        bool              `json:"readOnly,omitempty"`        // 以只读方式挂载根文件系统
 }
 
-func NewParentProcess() (*exec.Cmd, *os.File) {
+func NewParentProcess(containerInfo *ContainerInfo) (*exec.Cmd, *os.File) {
 	readPipe, writePipe, err := NewPipe()
 	if err != nil {
 		log.Errorf("New pipe error %v", err)
 		return nil, nil
 	}
-	cmd := exec.Command("/proc/self/exe", "init")
+	// init 进程与 monitor 一样显式传入状态根目录，rootless 模式下不依赖 XDG_RUNTIME_DIR
+	cmd := exec.Command("/proc/self/exe", "--root", RootDir, "init")
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
 			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
 	}
+	// 启用 user namespace 时，容器内的 root 映射为宿主机上的普通用户
+	// 其他 namespace 与 user namespace 在同一次 clone 中创建，归属于新的 user namespace
+	if len(containerInfo.UidMappings) > 0 {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = toSysProcIDMap(containerInfo.UidMappings)
+		cmd.SysProcAttr.GidMappings = toSysProcIDMap(containerInfo.GidMappings)
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = true
+		// 宿主机 root 在新的 user namespace 中没有映射，需要切换为容器内的 root，exec 之后才能保留 capability
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+	}
 
 	// UNIX会在子进程启动前会将ExtraFiles中的文件描述符从3开始依次往后分配，也就是说描述符是属于父进程
 	// 启动子进程后 子进程会继承父进程的文件描述符表
@@ -69,23 +121,53 @@ func NewPipe() (*os.File, *os.File, error) {
 	return read, write, nil
 }
 
-func NewWorkSpace(containerName string, ImageName string, volumes []string) (string, error) {
-	log.Infof("Creating workspace for container %s", containerName)
-	containerDir := fmt.Sprintf(DefaultInfoLocation, containerName)
+func NewWorkSpace(containerInfo *ContainerInfo) (string, error) {
+	containerId := containerInfo.Id
+	log.Infof("Creating workspace for container %s", containerId)
+	containerDir := containerDir(containerId)
 	ufsDir := path.Join(containerDir, "ufs")
 	mntURL := path.Join(containerDir, "mnt")
-	readOnlyLayerDir, err := CreateReadOnlyLayer(ImageName)
-	if err != nil {
-		return "", err
+	// create 之后 start，或者容器启动失败后重试时挂载点已经存在，直接复用
+	if isMountPoint(mntURL) {
+		log.Infof("Workspace of container %s is already mounted at %s", containerId, mntURL)
+		return mntURL, nil
+	}
+	var readOnlyLayerDir string
+	if len(containerInfo.UidMappings) > 0 {
+		dir, err := createRemappedReadOnlyLayer(containerInfo)
+		if err != nil {
+			return "", err
+		}
+		readOnlyLayerDir = dir
+	} else {
+		dir, err := CreateReadOnlyLayer(containerInfo.Image)
+		if err != nil {
+			return "", err
+		}
+		readOnlyLayerDir = dir
 	}
 	writeLayerDir, workLayerDir, err := CreateWriterLayer(ufsDir)
 	if err != nil {
 		return "", err
 	}
+	// 可写层的根目录属于容器内的 root，容器内新建的文件属主本身就是宿主机上映射后的 ID
+	if len(containerInfo.UidMappings) > 0 {
+		if err := allowRemappedRootTraversal(containerInfo); err != nil {
+			log.Errorf("Change permission of container %s dir error: %v", containerId, err)
+			return "", err
+		}
+		uid, gid := remappedRoot(containerInfo)
+		for _, dir := range []string{writeLayerDir, workLayerDir} {
+			if err := os.Lchown(dir, uid, gid); err != nil {
+				log.Errorf("Chown %s error: %v", dir, err)
+				return "", err
+			}
+		}
+	}
 	if err := CreateMountPoint(writeLayerDir, workLayerDir, readOnlyLayerDir, mntURL); err != nil {
 		return "", err
 	}
-	for _, v := range volumes {
+	for _, v := range containerInfo.Volume {
 		parts := strings.Split(v, ":")
 
 		if len(parts) < 2 {
@@ -102,6 +184,7 @@ func NewWorkSpace(containerName string, ImageName string, volumes []string) (str
 			mode = parts[2]
 		}
 
+		// 卷是用户自己的数据，不修改其中文件的属主，使用 -userns-remap 时需要用户自行 chown
 		CreateVolume(source, target, mntURL, mode)
 	}
 	return mntURL, nil
@@ -133,7 +216,12 @@ func CreateReadOnlyLayer(imageName string) (string, error) {
 
 	// 执行解压命令
 	log.Infof("Extracting %s to %s", imageFilePath, targetDir)
-	if err := exec.Command("tar", "-xvf", imageFilePath, "-C", targetDir).Run(); err != nil {
+	args := []string{"-xvf", imageFilePath, "-C", targetDir}
+	// rootless 模式下只映射了当前用户时，无法保留镜像中文件的属主
+	if Rootless && singleIDMapped() {
+		args = append(args, "--no-same-owner")
+	}
+	if err := exec.Command("tar", args...).Run(); err != nil {
 		log.Errorf("Tar extract %s error. %v", imageFilePath, err)
 		// 如果解压失败，建议清理掉创建失败的目录，防止下次误判
 		os.RemoveAll(targetDir)
@@ -186,10 +274,9 @@ func CreateMountPoint(writeLayerDir string, workLayerDir string, readOnlyLayer s
 	return nil
 }
 
-func DeleteWorkSpace(containerName string) error {
-	containerDir := fmt.Sprintf(DefaultInfoLocation, containerName)
+func DeleteWorkSpace(containerId string) error {
+	containerDir := containerDir(containerId)
 	rootUrl := path.Join(containerDir, "ufs")
-	mntUrl := path.Join(containerDir, "mnt")
 
 	// 读取容器信息 获取卷信息
 	configFilePath := path.Join(containerDir, ConfigName)
@@ -203,12 +290,24 @@ func DeleteWorkSpace(containerName string) error {
 		log.Errorf("Unmarshal container info error: %v", err)
 		return err
 	}
-	volumes := containerInfo.Volume
-	DeleteMountPoint(mntUrl, volumes)
+	UnmountWorkSpace(containerId, containerInfo.Volume)
 	DeleteWriteLayer(rootUrl)
 	return nil
 }
 
+// 卸载容器的卷和 overlay 挂载点，保留可写层
+// 容器退出时调用，挂载点已经被卸载过(包括宿主机重启后)则直接返回
+func UnmountWorkSpace(containerId string, volumes []string) {
+	mntUrl := path.Join(containerDir(containerId), "mnt")
+	if _, err := os.Stat(mntUrl); os.IsNotExist(err) {
+		return
+	}
+	if !isMountPoint(mntUrl) {
+		return
+	}
+	DeleteMountPoint(mntUrl, volumes)
+}
+
 func DeleteMountPoint(mntUrl string, volumes []string) {
 	// 先卸载所有卷挂载点
 	for _, v := range volumes {
@@ -307,34 +406,57 @@ func DeleteMountPointOfVolume(mntUrl string, target string) {
 }
 
 func CommitContainer(containerName string, imageName string) error {
-	containerURL := fmt.Sprintf(DefaultInfoLocation, containerName)
-	if !checkContainerExistsByName(containerName) {
-		log.Errorf("Container name %s does not exist", containerName)
-		return fmt.Errorf("container name %s does not exist", containerName)
+	containerInfo, err := GetContainerInfo(containerName)
+	if err != nil {
+		log.Errorf("Get container %s info error: %v", containerName, err)
+		return err
+	}
+	mntURL := path.Join(containerDir(containerInfo.Id), "mnt")
+	// 已经停止的容器(以及 rootless 模式下其他 mount namespace 中运行的容器)的工作空间没有挂载，临时挂载后再打包
+	if !isMountPoint(mntURL) {
+		if _, err := NewWorkSpace(containerInfo); err != nil {
+			log.Errorf("Mount workspace of container %s error: %v", containerName, err)
+			return err
+		}
+		defer UnmountWorkSpace(containerInfo.Id, containerInfo.Volume)
+	}
+	ownerMapArgs, cleanup, err := tarOwnerMapArgs(mntURL, containerInfo)
+	if err != nil {
+		log.Errorf("Map file owners of container %s error: %v", containerName, err)
+		return err
 	}
-	mntURL := path.Join(containerURL, "mnt")
+	defer cleanup()
 	imageURL := path.Join(IMAGEDIR, imageName+".tar")
-	cmd := exec.Command("tar", "-cvf", imageURL, "-C", mntURL, ".")
+	args := append([]string{"-cvf", imageURL}, ownerMapArgs...)
+	cmd := exec.Command("tar", append(args, "-C", mntURL, ".")...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		log.Errorf("Commit container %s failed: %v, output: %s", containerName, err, string(output))
 		return err
 	}
+	// 容器的 label 已经包含了原镜像的 label，一起保存为新镜像的 label
+	if err := saveImageConfig(imageName, &ImageConfig{Labels: containerInfo.Labels}); err != nil {
+		log.Errorf("Save image %s config error: %v", imageName, err)
+		return err
+	}
 	log.Infof("Commit container %s to image %s successfully", containerName, imageName)
 	return nil
 }
 
-// 打印正在运行的容器信息
-func ListContainers() {
-	dirURL := fmt.Sprintf(DefaultInfoLocation, "")
-	dirURL = dirURL[:len(dirURL)-1]
+// 读取所有容器的信息
+// config.json 中的状态可能已经过期(例如宿主机重启后仍然是 running)，这里根据记录的 PID 修正
+func LoadAllContainers() ([]*ContainerInfo, error) {
+	dirURL := containerDir("")
 	files, err := ioutil.ReadDir(dirURL)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
 		log.Errorf("Read dir %s error: %v", dirURL, err)
-		return
+		return nil, err
 	}
 
-	var containers []ContainerInfo
+	var containers []*ContainerInfo
 	for _, file := range files {
 		containerDir := path.Join(dirURL, file.Name())
 		configFilePath := path.Join(containerDir, ConfigName)
@@ -348,34 +470,66 @@ func ListContainers() {
 			log.Errorf("Unmarshal container info error: %v", err)
 			continue
 		}
-		containers = append(containers, containerInfo)
+		if (containerInfo.Status == RUNNING || containerInfo.Status == PAUSED || containerInfo.Status == RESTARTING) &&
+			!IsContainerRunning(&containerInfo) {
+			containerInfo.Status = EXIT
+			containerInfo.Pid = ""
+		}
+		containers = append(containers, &containerInfo)
+	}
+	return containers, nil
+}
+
+// 列出容器，all 为 false 且没有按状态过滤时只列出运行中的容器
+func ListContainers(all bool, filters Filters, opts format.Options) error {
+	containers, err := LoadAllContainers()
+	if err != nil {
+		return err
+	}
+	if _, ok := filters["status"]; ok {
+		all = true
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"ID", "Name", "PID", "Command", "CreatedTime", "Status", "PORTS"})
+	items := make([]interface{}, 0, len(containers))
+	for _, containerInfo := range containers {
+		if !all && !IsContainerRunning(containerInfo) {
+			continue
+		}
+		if !filters.Match(containerInfo) {
+			continue
+		}
+		items = append(items, containerInfo)
+	}
+	return format.Write(os.Stdout, items, containerColumns, func(item interface{}) string {
+		return item.(*ContainerInfo).Id
+	}, opts)
+}
 
-	for _, container := range containers {
-		portStr := strings.Join(container.PortMapping, ",")
+// ps 表格中的列
+var containerColumns = []format.Column{
+	{Header: "ID", Width: 12, Cut: true, Value: func(item interface{}) string { return item.(*ContainerInfo).Id }},
+	{Header: "Name", Value: func(item interface{}) string { return item.(*ContainerInfo).Name }},
+	{Header: "PID", Value: func(item interface{}) string { return item.(*ContainerInfo).Pid }},
+	{Header: "Command", Width: 20, Value: func(item interface{}) string { return item.(*ContainerInfo).Command }},
+	{Header: "CreatedTime", Value: func(item interface{}) string { return item.(*ContainerInfo).CreatedTime }},
+	{Header: "Status", Value: func(item interface{}) string { return statusText(item.(*ContainerInfo)) }},
+	{Header: "RESTARTS", Value: func(item interface{}) string { return strconv.Itoa(item.(*ContainerInfo).RestartCount) }},
+	{Header: "PORTS", Value: func(item interface{}) string {
+		portStr := strings.Join(item.(*ContainerInfo).PortMapping, ",")
 		if portStr == "" {
 			portStr = "-"
 		}
-
-		table.Append([]string{
-			container.Id,
-			container.Name,
-			container.Pid,
-			container.Command,
-			container.CreatedTime,
-			container.Status,
-			portStr,
-		})
-	}
-	table.Render()
+		return portStr
+	}},
 }
 
 func LogContainer(containerName string) {
-	containerDir := fmt.Sprintf(DefaultInfoLocation, containerName)
-	logFilePath := path.Join(containerDir, ContainerLogFile)
+	containerId, err := ResolveContainerID(containerName)
+	if err != nil {
+		log.Errorf("Get container %s error: %v", containerName, err)
+		return
+	}
+	logFilePath := path.Join(containerDir(containerId), ContainerLogFile)
 	content, err := ioutil.ReadFile(logFilePath)
 	if err != nil {
 		log.Errorf("Read log file %s error: %v", logFilePath, err)
@@ -384,66 +538,105 @@ func LogContainer(containerName string) {
 	fmt.Fprint(os.Stdout, string(content))
 }
 
-func StopContainer(containerName string) error {
-	containerDir := fmt.Sprintf(DefaultInfoLocation, containerName)
-	configFilePath := path.Join(containerDir, ConfigName)
-
-	content, err := os.ReadFile(configFilePath)
+// 停止容器: 先发送容器的停止信号(默认 SIGTERM)，timeout 秒后容器仍未退出再发送 SIGKILL
+func StopContainer(containerName string, timeout int) error {
+	info, err := GetContainerInfo(containerName)
 	if err != nil {
-		log.Errorf("Read file %s error: %v", configFilePath, err)
+		log.Errorf("Get container %s info error: %v", containerName, err)
 		return err
 	}
+	containerInfo := *info
 
-	var containerInfo ContainerInfo
-	if err := json.Unmarshal(content, &containerInfo); err != nil {
-		log.Errorf("Unmarshal container info error: %v", err)
-		return err
+	// 检查逻辑状态：如果还没有启动、已经是 STOPPED 或已退出，直接返回
+	if containerInfo.Status == CREATED || containerInfo.Status == STOPPED || containerInfo.Status == EXIT {
+		log.Infof("Container %s has already been stopped, skip kill.", containerName)
+		return nil
 	}
 
-	// 检查逻辑状态：如果已经是 STOPPED，直接返回
-	if containerInfo.Status == STOPPED {
-		log.Infof("Container %s has already been stopped, skip kill.", containerName)
+	// 被冻结的进程无法处理信号，需要先解冻
+	if containerInfo.Status == PAUSED {
+		if err := cgroups.NewCgroupManager(containerInfo.CgroupPath).Thaw(); err != nil {
+			log.Errorf("Thaw container %s error: %v", containerName, err)
+			return err
+		}
+	}
+
+	// 先标记容器被手动停止，等待容器的进程看到标记后不会按照重启策略重启容器
+	// 标记之前 monitor 可能刚刚重启了容器，之后的操作使用锁内读到的最新进程号
+	latest, err := UpdateContainerInfo(containerInfo.Id, func(latest *ContainerInfo) error {
+		latest.ManuallyStopped = true
 		return nil
+	})
+	if err != nil {
+		return err
 	}
+	containerInfo = *latest
 
 	// 检查系统进程：使用 kill -0 探测进程是否存在
 	// kill -0 不会发送信号，但会进行权限和进程存在性检查
+	// 进程号已经被其他进程复用时(例如宿主机重启后)也不能发送信号
 	pidInt, _ := strconv.Atoi(containerInfo.Pid)
-	if err := syscall.Kill(pidInt, 0); err != nil {
+	if !isProcessRunning(pidInt, containerInfo.PidStartTime) {
 		log.Warnf("Process %d for container %s not found in system, skipping kill.", pidInt, containerName)
 	} else {
-		// 只有进程存在才执行真正的 kill
-		log.Infof("Killing container %s (pid: %s)", containerName, containerInfo.Pid)
-		cmd := exec.Command("kill", "-9", containerInfo.Pid)
-		if output, err := cmd.CombinedOutput(); err != nil {
-			log.Errorf("Kill container %s failed: %v, output: %s", containerName, err, string(output))
+		// 只有进程存在才发送信号
+		stopSignal := containerInfo.StopSignal
+		if stopSignal == "" {
+			stopSignal = DefaultStopSignal
+		}
+		sig, err := ParseSignal(stopSignal)
+		if err != nil {
 			return err
 		}
+		log.Infof("Stopping container %s (pid: %s) with %s", containerName, containerInfo.Pid, stopSignal)
+		if err := syscall.Kill(pidInt, sig); err != nil && err != syscall.ESRCH {
+			log.Errorf("Send %s to container %s failed: %v", stopSignal, containerName, err)
+			return err
+		}
+		// 进程退出后才能删除 cgroup
+		if !waitProcessExit(pidInt, time.Duration(timeout)*time.Second) {
+			log.Warnf("Container %s (pid: %d) did not exit within %d seconds, killing it", containerName, pidInt, timeout)
+			if err := syscall.Kill(pidInt, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				log.Errorf("Kill container %s failed: %v", containerName, err)
+				return err
+			}
+			if !waitProcessExit(pidInt, 5*time.Second) {
+				log.Warnf("Container %s (pid: %d) is still alive after kill", containerName, pidInt)
+			}
+		}
 	}
 
-	// 更新容器状态并写回文件
-	containerInfo.Status = STOPPED
-	containerInfo.Pid = "" // 停止后清空 PID 也是一种常见的做法
-	updatedContent, err := json.Marshal(containerInfo)
-	if err != nil {
-		log.Errorf("Marshal updated container info error: %v", err)
-		return err
+	// 等待容器进程的 monitor 记录退出信息并回收资源，避免与 monitor 同时改写 config.json
+	monitorPid, _ := strconv.Atoi(containerInfo.MonitorPid)
+	if monitorPid > 0 && monitorPid != os.Getpid() {
+		if !waitProcessExit(monitorPid, 10*time.Second) {
+			log.Warnf("Monitor %d of container %s is still alive", monitorPid, containerName)
+		}
+		// monitor 可能已经删除了容器(--rm)
+		if !checkContainerExists(containerInfo.Id) {
+			return nil
+		}
 	}
 
-	if err := os.WriteFile(configFilePath, updatedContent, 0622); err != nil {
-		log.Errorf("Write updated container info to file %s error: %v", configFilePath, err)
-		return err
-	}
+	// 删除容器的 cgroup
+	destroyContainerCgroup(&containerInfo)
 
-	return nil
+	// 在 monitor 写入的退出信息基础上更新容器状态并写回文件
+	_, err = UpdateContainerInfo(containerInfo.Id, func(latest *ContainerInfo) error {
+		latest.Status = STOPPED
+		latest.Pid = "" // 停止后清空 PID 也是一种常见的做法
+		return nil
+	})
+	return err
 }
 
-func GetContainerInfoByName(containerName string) (*ContainerInfo, error) {
-	containerDir := fmt.Sprintf(DefaultInfoLocation, containerName)
-	if !checkContainerExistsByName(containerName) {
-		return nil, fmt.Errorf("container name %s does not exist", containerName)
+// 根据容器名称、完整 ID 或唯一的 ID 前缀读取容器信息
+func GetContainerInfo(containerName string) (*ContainerInfo, error) {
+	containerId, err := ResolveContainerID(containerName)
+	if err != nil {
+		return nil, err
 	}
-	configFilePath := path.Join(containerDir, ConfigName)
+	configFilePath := path.Join(containerDir(containerId), ConfigName)
 	content, err := ioutil.ReadFile(configFilePath)
 	if err != nil {
 		log.Errorf("Read file %s error: %v", configFilePath, err)
@@ -457,141 +650,160 @@ func GetContainerInfoByName(containerName string) (*ContainerInfo, error) {
 	return &containerInfo, nil
 }
 
-func CreateContainerInfoByName(containerName string, pid int, commandArray []string, volume []string, portMapping []string) (*ContainerInfo, error) {
-	containerId := randStringBytes(10)
-	if containerName == "" {
-		containerName = containerId
+// 根据命令行解析出的容器配置生成容器信息并写入 config.json，容器处于 created 状态
+func CreateContainerInfo(spec *ContainerInfo) (*ContainerInfo, error) {
+	containerId, err := newContainerID()
+	if err != nil {
+		return nil, err
+	}
+	containerInfo := *spec
+	if containerInfo.Name == "" {
+		containerInfo.Name = containerId[:12]
 	}
+	containerName := containerInfo.Name
 	log.Infof("Creating container info for %s", containerName)
-	if checkContainerExistsByName(containerName) {
-		return nil, fmt.Errorf("container name %s already exists", containerName)
-	}
-	command := strings.Join(commandArray, " ")
-	CreatedTime := time.Now().Format("2006-01-02 15:04:05")
-	containerInfo := &ContainerInfo{
-		Name:        containerName,
-		Pid:         strconv.Itoa(pid),
-		Id:          containerId,
-		Command:     command,
-		CreatedTime: CreatedTime,
-		Status:      RUNNING,
-		Volume:      volume,
-		PortMapping: portMapping,
-	}
-	jsonBytes, err := json.Marshal(containerInfo)
+	containerInfo.Id = containerId
+	containerInfo.Pid = ""
+	containerInfo.Command = strings.Join(containerInfo.CommandArray, " ")
+	containerInfo.CreatedTime = time.Now().Format(TimeFormat)
+	containerInfo.Status = CREATED
+	// 继承镜像的 label
+	imageConfig, err := LoadImageConfig(containerInfo.Image)
 	if err != nil {
-		log.Errorf("Record container info error: %v", err)
+		log.Errorf("Load image %s config error: %v", containerInfo.Image, err)
 		return nil, err
 	}
-	containerDir := fmt.Sprintf(DefaultInfoLocation, containerName)
+	containerInfo.Labels = mergeLabels(imageConfig.Labels, containerInfo.Labels)
+	// 先创建容器目录再登记名称，system reconcile 不会把刚登记的名称当作残留的索引删除
+	containerDir := containerDir(containerId)
 	if err := os.MkdirAll(containerDir, 0622); err != nil {
 		log.Errorf("MkdirAll %s error: %v", containerDir, err)
 		return nil, err
 	}
-	fileName := path.Join(containerDir, ConfigName)
-	if err := os.WriteFile(fileName, jsonBytes, 0622); err != nil {
-		log.Errorf("Write file %s error: %v", fileName, err)
+	if err := allowRootlessHostAccess(containerDir); err != nil {
+		log.Errorf("Chmod %s error: %v", containerDir, err)
+		os.RemoveAll(containerDir)
 		return nil, err
 	}
-	return containerInfo, nil
-}
-
-func UpdateContainerInfoByName(containerName string, update *ContainerInfo) error {
-	if !checkContainerExistsByName(containerName) {
-		return fmt.Errorf("container %s does not exist", containerName)
+	if err := reserveContainerName(containerName, containerId); err != nil {
+		os.RemoveAll(containerDir)
+		return nil, err
 	}
+	if err := SaveContainerInfo(&containerInfo); err != nil {
+		releaseContainerName(containerName)
+		os.RemoveAll(containerDir)
+		return nil, err
+	}
+	return &containerInfo, nil
+}
 
-	containerDir := fmt.Sprintf(DefaultInfoLocation, containerName)
-	configFilePath := path.Join(containerDir, ConfigName)
-
-	// 读取旧配置
-	content, err := ioutil.ReadFile(configFilePath)
+// 将容器信息完整写回 config.json
+func SaveContainerInfo(containerInfo *ContainerInfo) error {
+	configFilePath := path.Join(containerDir(containerInfo.Id), ConfigName)
+	content, err := json.MarshalIndent(containerInfo, "", "  ")
 	if err != nil {
+		log.Errorf("Marshal container info error: %v", err)
 		return err
 	}
-
-	var old ContainerInfo
-	if err := json.Unmarshal(content, &old); err != nil {
+	// 先写临时文件再 rename，进程崩溃时不会留下被截断的 config.json
+	if err := fileutil.WriteFileAtomic(configFilePath, content, 0644); err != nil {
+		log.Errorf("Write container info to file %s error: %v", configFilePath, err)
 		return err
 	}
+	return nil
+}
 
-	// 字段更新
-	if update.Id != old.Id || update.Name != old.Name || update.CreatedTime != old.CreatedTime {
-		return fmt.Errorf("container ID, Name, and CreatedTime cannot be updated")
-	}
-	if update.Pid != "" {
-		old.Pid = update.Pid
-	}
-	if update.Command != "" {
-		old.Command = update.Command
-	}
-	if update.Status != "" {
-		old.Status = update.Status
-	}
-	if update.Volume != nil {
-		old.Volume = update.Volume
-	}
+// 对容器状态加跨进程排它锁，锁文件放在容器目录下
+// 容器目录不存在(容器已被删除)时返回错误
+func lockContainer(containerId string) (func(), error) {
+	return fileutil.Lock(path.Join(containerDir(containerId), ConfigLockName))
+}
+
+// 启动容器的整个过程(检查状态、启动 monitor、等待容器进程启动)持有的锁，避免并发的 start 启动多个 monitor
+// 启动过程中 monitor 需要修改容器信息，因此不能使用 lockContainer
+func LockContainerStart(containerId string) (func(), error) {
+	return fileutil.Lock(path.Join(containerDir(containerId), StartLockName))
+}
 
-	// 写回文件
-	newContent, err := json.MarshalIndent(old, "", "  ")
+// 在锁内重新读取容器信息，交给 update 修改后写回 config.json，返回修改后的容器信息
+// 所有对已有容器的 读取-修改-写回 都要通过它完成，避免并发的命令互相覆盖对方的修改
+// update 中不能等待容器进程，也不能再调用加锁的函数
+func UpdateContainerInfo(containerId string, update func(*ContainerInfo) error) (*ContainerInfo, error) {
+	unlock, err := lockContainer(containerId)
 	if err != nil {
-		return err
+		log.Errorf("Lock container %s error: %v", containerId, err)
+		return nil, err
 	}
+	defer unlock()
 
-	return ioutil.WriteFile(configFilePath, newContent, 0644)
+	containerInfo, err := GetContainerInfo(containerId)
+	if err != nil {
+		return nil, err
+	}
+	if err := update(containerInfo); err != nil {
+		return nil, err
+	}
+	if err := SaveContainerInfo(containerInfo); err != nil {
+		return nil, err
+	}
+	return containerInfo, nil
 }
 
-func deleteContainerInfo(containerName string) error {
-	containerDir := fmt.Sprintf(DefaultInfoLocation, containerName)
+// 删除容器目录和容器的名称索引
+func deleteContainerInfo(containerInfo *ContainerInfo) error {
+	containerDir := containerDir(containerInfo.Id)
+	// 等待正在修改容器信息的命令完成后再删除，之后的修改会因为容器目录不存在而失败
+	if unlock, err := lockContainer(containerInfo.Id); err == nil {
+		defer unlock()
+	}
 	if err := os.RemoveAll(containerDir); err != nil {
 		log.Errorf("Remove file %s error: %v", containerDir, err)
 		return err
 	}
-	return nil
+	return releaseContainerName(containerInfo.Name)
 }
 
-func checkContainerExistsByName(containerName string) bool {
-	containerDir := fmt.Sprintf(DefaultInfoLocation, containerName)
-	if _, err := os.Stat(containerDir); err == nil {
-		return true
+// 删除容器的 cgroup，旧版本创建的容器没有记录 cgroup 路径则跳过
+func destroyContainerCgroup(containerInfo *ContainerInfo) {
+	if containerInfo.CgroupPath == "" {
+		return
 	}
-	return false
+	cgroups.NewCgroupManager(containerInfo.CgroupPath).Destroy()
 }
 
-func randStringBytes(n int) string {
-	const letterBytes = "0123456789"
-	rand.Seed(time.Now().UnixNano())
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+func checkContainerExists(containerId string) bool {
+	if _, err := os.Stat(containerDir(containerId)); err == nil {
+		return true
 	}
-	return string(b)
+	return false
 }
 
 func DeleteContainer(containerName string, force_delete bool) error {
 	// 停止容器
 	if force_delete {
-		if err := StopContainer(containerName); err != nil {
+		if err := StopContainer(containerName, DefaultStopTimeout); err != nil {
 			log.Errorf("Stop container error: %v", err)
 			return err
 		}
 	}
 	// 检查容器是否停止
-	containerInfo, err := GetContainerInfoByName(containerName)
+	containerInfo, err := GetContainerInfo(containerName)
 	if err != nil {
 		log.Errorf("Get container info error: %v", err)
 		return err
 	}
-	if containerInfo.Status == RUNNING {
+	if containerInfo.Status == RUNNING || containerInfo.Status == PAUSED {
 		return fmt.Errorf("cannot remove a running container, please stop it first")
 	}
+	// 删除容器的 cgroup
+	destroyContainerCgroup(containerInfo)
 	// 卸载挂载点 & 删除容器文件系统
-	if err := DeleteWorkSpace(containerName); err != nil {
+	if err := DeleteWorkSpace(containerInfo.Id); err != nil {
 		log.Errorf("Delete workspace error: %v", err)
 		return err
 	}
 	// 删除容器信息
-	if err := deleteContainerInfo(containerName); err != nil {
+	if err := deleteContainerInfo(containerInfo); err != nil {
 		log.Errorf("Delete container info error: %v", err)
 		return err
 	}
@@ -599,7 +811,7 @@ func DeleteContainer(containerName string, force_delete bool) error {
 }
 
 // ShowAllImages 列出 IMAGEDIR 目录下所有的镜像名称及其创建时间
-func ShowAllImages() error {
+func ShowAllImages(opts format.Options) error {
 	// 读取镜像存储目录
 	files, err := os.ReadDir(IMAGEDIR)
 	if err != nil {
@@ -607,63 +819,31 @@ func ShowAllImages() error {
 		return err
 	}
 
-	// 定义一个结构体来存储镜像信息
-	type imageInfo struct {
-		name    string
-		created string
-	}
-
-	var images []imageInfo
+	var images []interface{}
 	for _, file := range files {
 		// 仅处理文件，且后缀为 .tar
 		if !file.IsDir() && strings.HasSuffix(file.Name(), ".tar") {
-			// 获取文件信息（包含时间戳）
-			info, err := file.Info()
+			image, err := GetImageInfo(strings.TrimSuffix(file.Name(), ".tar"))
 			if err != nil {
 				continue // 如果获取不到信息则跳过
 			}
-
-			imageName := strings.TrimSuffix(file.Name(), ".tar")
-			// 格式化时间为：2023-10-27 10:00:00
-			createdTime := info.ModTime().Format("2006-01-02 15:04:05")
-
-			images = append(images, imageInfo{
-				name:    imageName,
-				created: createdTime,
-			})
+			images = append(images, image)
 		}
 	}
 
 	// 检查镜像列表是否为空
-	if len(images) == 0 {
+	if len(images) == 0 && opts.Format == "" && !opts.Quiet {
 		fmt.Printf("No images found in %s\n", IMAGEDIR)
 		return nil
 	}
 
-	// 初始化表格渲染器
-	table := tablewriter.NewWriter(os.Stdout)
-	// 修改表头，增加 CREATED
-	table.SetHeader([]string{"IMAGE NAME", "CREATED"})
-
-	// --- 样式配置 ---
-	table.SetAutoFormatHeaders(true)
-	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.SetCenterSeparator("")
-	table.SetColumnSeparator("")
-	table.SetRowSeparator("")
-	table.SetHeaderLine(false)
-	table.SetBorder(false)
-	table.SetAutoWrapText(false)
-	table.SetTablePadding("\t")
-
-	// 填充数据
-	for _, img := range images {
-		table.Append([]string{img.name, img.created})
-	}
-
-	// 渲染输出
-	table.Render()
+	return format.Write(os.Stdout, images, imageColumns, func(item interface{}) string {
+		return item.(*ImageInfo).Name
+	}, opts)
+}
 
-	return nil
+// images 表格中的列
+var imageColumns = []format.Column{
+	{Header: "IMAGE NAME", Value: func(item interface{}) string { return item.(*ImageInfo).Name }},
+	{Header: "CREATED", Value: func(item interface{}) string { return item.(*ImageInfo).Created }},
 }
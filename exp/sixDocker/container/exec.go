@@ -8,6 +8,7 @@ import (
 
 	"os/exec"
 	_ "sixDocker/nsenter"
+	"sixDocker/seccomp"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -16,12 +17,24 @@ import (
 const ENV_EXEC_PID = "sixDocker_pid"
 const ENV_EXEC_CMD = "sixDocker_cmd"
 
+// exec 进入容器的进程保留的 capability 位图(十六进制)，没有设置时不限制
+const ENV_EXEC_CAPS = "sixDocker_caps"
+
+// exec 进入容器的进程使用的 seccomp 过滤器(编码后的 BPF 程序)，没有设置时不限制
+const ENV_EXEC_SECCOMP = "sixDocker_seccomp"
+
+// exec 进入容器的进程是否设置 no_new_privs
+const ENV_EXEC_NO_NEW_PRIVS = "sixDocker_no_new_privs"
+
 func ExecContainer(containerName string, commandArray []string) error {
 	// 获取容器信息，拿到 PID
-	containerInfo, err := GetContainerInfoByName(containerName)
+	containerInfo, err := GetContainerInfo(containerName)
 	if err != nil {
 		return fmt.Errorf("Get container %s info error: %v", containerName, err)
 	}
+	if containerInfo.Status == PAUSED {
+		return fmt.Errorf("container %s is paused, unpause the container before exec", containerName)
+	}
 	pid := containerInfo.Pid
 	if pid == "" {
 		return fmt.Errorf("cannot find container %s pid", containerName)
@@ -64,6 +77,20 @@ func ExecContainer(containerName string, commandArray []string) error {
 	// 注入 C 语言层拦截需要的关键变量
 	finalEnv = append(finalEnv, fmt.Sprintf("%s=%s", ENV_EXEC_PID, pid))
 	finalEnv = append(finalEnv, fmt.Sprintf("%s=%s", ENV_EXEC_CMD, cmdStr))
+	// 与容器的 init 进程使用相同的 no_new_privs、seccomp 过滤器和 capability
+	if containerInfo.NoNewPrivileges {
+		finalEnv = append(finalEnv, fmt.Sprintf("%s=1", ENV_EXEC_NO_NEW_PRIVS))
+	}
+	if containerInfo.SeccompProfile != nil {
+		filter, err := seccomp.Compile(containerInfo.SeccompProfile, containerInfo.Capabilities)
+		if err != nil {
+			return fmt.Errorf("Compile seccomp profile of container %s error: %v", containerName, err)
+		}
+		finalEnv = append(finalEnv, fmt.Sprintf("%s=%s", ENV_EXEC_SECCOMP, seccomp.EncodeFilter(filter)))
+	}
+	if containerInfo.Capabilities != nil {
+		finalEnv = append(finalEnv, fmt.Sprintf("%s=%x", ENV_EXEC_CAPS, CapabilityMask(containerInfo.Capabilities)))
+	}
 
 	// 注入容器原有的环境变量
 	for _, env := range containerEnvs {
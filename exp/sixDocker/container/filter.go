@@ -0,0 +1,90 @@
+// exp/sixDocker/container/filter.go
+
+package container
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 容器过滤条件，key 相同的多个条件满足任意一个即可，不同 key 的条件需要同时满足
+type Filters map[string][]string
+
+var validFilterKeys = map[string]bool{
+	"status":   true,
+	"name":     true,
+	"label":    true,
+	"network":  true,
+	"ancestor": true,
+}
+
+var validStatusFilters = map[string]bool{
+	CREATED:    true,
+	RESTARTING: true,
+	RUNNING:    true,
+	PAUSED:     true,
+	EXIT:       true,
+	STOPPED:    true,
+}
+
+// 解析 --filter key=value 参数
+func ParseFilters(args []string) (Filters, error) {
+	filters := Filters{}
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("bad format of filter (expected name=value): %s", arg)
+		}
+		key, value := strings.ToLower(parts[0]), parts[1]
+		if !validFilterKeys[key] {
+			return nil, fmt.Errorf("invalid filter '%s'", key)
+		}
+		if key == "status" && !validStatusFilters[value] {
+			return nil, fmt.Errorf("invalid filter 'status=%s'", value)
+		}
+		filters[key] = append(filters[key], value)
+	}
+	return filters, nil
+}
+
+func (filters Filters) Match(containerInfo *ContainerInfo) bool {
+	for key, values := range filters {
+		matched := false
+		for _, value := range values {
+			if filters.matchOne(key, value, containerInfo) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (filters Filters) matchOne(key string, value string, containerInfo *ContainerInfo) bool {
+	switch key {
+	case "status":
+		// 被 stop 停止的容器也属于 exited
+		if value == EXIT {
+			return containerInfo.Status == EXIT || containerInfo.Status == STOPPED
+		}
+		return containerInfo.Status == value
+	case "name":
+		return strings.Contains(containerInfo.Name, value)
+	case "label":
+		// label=key 只要求存在该 label，label=key=value 要求值也相同
+		parts := strings.SplitN(value, "=", 2)
+		labelValue, ok := containerInfo.Labels[parts[0]]
+		if !ok {
+			return false
+		}
+		return len(parts) == 1 || labelValue == parts[1]
+	case "network":
+		return containerInfo.Network == value
+	case "ancestor":
+		return containerInfo.Image == value
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+// exp/sixDocker/container/filter_test.go
+
+package container
+
+import (
+	"testing"
+)
+
+func TestFiltersMatch(t *testing.T) {
+	containerInfo := &ContainerInfo{
+		Name:    "web-1",
+		Status:  STOPPED,
+		Image:   "busybox",
+		Network: "docker0",
+		Labels:  map[string]string{"team": "infra"},
+	}
+	cases := []struct {
+		args  []string
+		match bool
+	}{
+		{nil, true},
+		{[]string{"status=exited"}, true},
+		{[]string{"status=running"}, false},
+		{[]string{"status=running", "status=stopped"}, true},
+		{[]string{"name=web"}, true},
+		{[]string{"name=db"}, false},
+		{[]string{"label=team"}, true},
+		{[]string{"label=team=infra", "ancestor=busybox", "network=docker0"}, true},
+		{[]string{"label=team=app"}, false},
+		{[]string{"name=web", "ancestor=nginx"}, false},
+	}
+	for _, c := range cases {
+		filters, err := ParseFilters(c.args)
+		if err != nil {
+			t.Fatalf("parse filters %v error: %v", c.args, err)
+		}
+		if got := filters.Match(containerInfo); got != c.match {
+			t.Errorf("filters %v match = %v, want %v", c.args, got, c.match)
+		}
+	}
+}
+
+func TestParseFiltersInvalid(t *testing.T) {
+	for _, arg := range []string{"status", "foo=bar", "status=dead", "name="} {
+		if _, err := ParseFilters([]string{arg}); err == nil {
+			t.Errorf("expected error for filter %s", arg)
+		}
+	}
+}
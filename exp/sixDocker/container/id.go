@@ -0,0 +1,109 @@
+// exp/sixDocker/container/id.go
+
+package container
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// 容器名称只能包含字母、数字和 _ . -，并以字母或数字开头
+var validContainerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// 容器 ID 及其前缀只包含小写十六进制字符
+var validContainerIDPrefix = regexp.MustCompile(`^[0-9a-f]{1,64}$`)
+
+// 生成 64 位十六进制的随机容器 ID
+func newContainerID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate container id error: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 容器目录以容器 ID 命名
+func containerDir(containerId string) string {
+	return fmt.Sprintf(DefaultInfoLocation, containerId)
+}
+
+// 名称索引: NameIndexLocation 目录下每个容器名称对应一个文件，文件内容为容器 ID
+// 使用 O_EXCL 创建索引文件，两个容器同时使用同一个名称时只有一个能成功
+func reserveContainerName(containerName string, containerId string) error {
+	if !validContainerName.MatchString(containerName) {
+		return fmt.Errorf("invalid container name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", containerName)
+	}
+	indexFile := fmt.Sprintf(NameIndexLocation, containerName)
+	if err := os.MkdirAll(fmt.Sprintf(NameIndexLocation, ""), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(indexFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("container name %s already exists", containerName)
+		}
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(containerId)
+	return err
+}
+
+func releaseContainerName(containerName string) error {
+	if err := os.Remove(fmt.Sprintf(NameIndexLocation, containerName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func lookupContainerName(containerName string) (string, bool) {
+	content, err := ioutil.ReadFile(fmt.Sprintf(NameIndexLocation, containerName))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(content)), true
+}
+
+// 将容器名称、完整 ID 或唯一的 ID 前缀解析为容器 ID
+// 既不是 ID 前缀也不是合法名称的引用(例如 .、.. 或包含 /)直接报错，不会被拼接到容器目录中
+func ResolveContainerID(containerRef string) (string, error) {
+	isID := validContainerIDPrefix.MatchString(containerRef)
+	if !isID && !validContainerName.MatchString(containerRef) {
+		return "", fmt.Errorf("no such container: %s", containerRef)
+	}
+	if isID {
+		if _, err := os.Stat(containerDir(containerRef)); err == nil {
+			return containerRef, nil
+		}
+	}
+	if containerId, ok := lookupContainerName(containerRef); ok {
+		return containerId, nil
+	}
+	if !isID {
+		return "", fmt.Errorf("no such container: %s", containerRef)
+	}
+
+	files, err := ioutil.ReadDir(containerDir(""))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	var matches []string
+	for _, file := range files {
+		if file.IsDir() && strings.HasPrefix(file.Name(), containerRef) {
+			matches = append(matches, file.Name())
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no such container: %s", containerRef)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("multiple containers found with prefix %s, please use a longer prefix", containerRef)
+	}
+}
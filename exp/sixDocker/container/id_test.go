@@ -0,0 +1,64 @@
+// exp/sixDocker/container/id_test.go
+
+package container
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestResolveContainerID(t *testing.T) {
+	root := t.TempDir()
+	oldInfo, oldNames := DefaultInfoLocation, NameIndexLocation
+	DefaultInfoLocation = path.Join(root, "containers") + "/%s"
+	NameIndexLocation = path.Join(root, "names") + "/%s"
+	defer func() {
+		DefaultInfoLocation, NameIndexLocation = oldInfo, oldNames
+	}()
+
+	ids := []string{"abc123", "abd456", "ffff00"}
+	for _, id := range ids {
+		if err := os.MkdirAll(containerDir(id), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := reserveContainerName("web", "abd456"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reserveContainerName("web", "ffff00"); err == nil {
+		t.Errorf("expected error when reserving a name twice")
+	}
+
+	cases := map[string]string{
+		"abc123": "abc123",
+		"abc":    "abc123",
+		"f":      "ffff00",
+		"web":    "abd456",
+	}
+	for ref, want := range cases {
+		got, err := ResolveContainerID(ref)
+		if err != nil || got != want {
+			t.Errorf("ResolveContainerID(%s) = %s, %v, want %s", ref, got, err, want)
+		}
+	}
+	for _, ref := range []string{"ab", "zzz", "", ".", "..", "../abc123", "ABC123", "-abc"} {
+		if _, err := ResolveContainerID(ref); err == nil {
+			t.Errorf("ResolveContainerID(%s) expected error", ref)
+		}
+	}
+}
+
+func TestNewContainerID(t *testing.T) {
+	id, err := newContainerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id) != 64 {
+		t.Errorf("container id %s has length %d, want 64", id, len(id))
+	}
+	other, _ := newContainerID()
+	if id == other {
+		t.Errorf("container ids should be unique")
+	}
+}
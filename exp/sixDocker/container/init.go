@@ -9,21 +9,45 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"runtime"
+	"sixDocker/seccomp"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
+// 由 start 通过管道发送给 init 进程的启动参数
+type InitSpec struct {
+	Command []string `json:"command"`
+	Init    bool     `json:"init"` // 是否由 init 进程作为 1 号进程托管用户命令
+	// 用户命令保留的 capability，为 null 时不限制
+	Capabilities []string `json:"capabilities"`
+	// 用户命令使用的 seccomp 配置，为 nil 时不限制
+	Seccomp *seccomp.Profile `json:"seccomp,omitempty"`
+	// 以只读方式挂载根文件系统，/tmp 和 /run 使用 tmpfs
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// 设置 no_new_privs，用户命令无法通过 setuid 程序或文件 capability 获得更多权限
+	NoNewPrivileges bool `json:"noNewPrivileges,omitempty"`
+}
+
 func RunContainerInitProcess() error {
 	// 从父进程的第4个文件描述符中读取管道传递过来的命令
-	cmdArray := readUserCommand()
-	if len(cmdArray) == 0 {
+	spec := readUserCommand()
+	if spec == nil || len(spec.Command) == 0 {
 		return fmt.Errorf("Run container get user command error, cmdArray is nil")
 	}
+	cmdArray := spec.Command
+
+	setUpLoopback()
 
 	// 设置根文件系统和挂载proc文件系统
 	// 后续 exec.LookPath 会在新的根文件系统中查找可执行文件
-	setUpMount()
+	if err := setUpMount(spec); err != nil {
+		log.Errorf("Set up mount error: %v", err)
+		return err
+	}
 
 	// 从当前进程看到的环境变量中查找可执行文件的路径
 	path, err := exec.LookPath(cmdArray[0])
@@ -32,6 +56,36 @@ func RunContainerInitProcess() error {
 		return err
 	}
 	log.Infof("Find path %s", path)
+	// no_new_privs、seccomp 和 capability 都是线程的属性，之后的 exec 必须在同一个线程中执行
+	runtime.LockOSThread()
+	if spec.NoNewPrivileges {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			log.Errorf("Set no_new_privs error: %v", err)
+			return err
+		}
+	}
+	// 没有设置 no_new_privs 时安装 seccomp 过滤器需要 CAP_SYS_ADMIN，在丢弃 capability 之前完成
+	if spec.Seccomp != nil {
+		filter, err := seccomp.Compile(spec.Seccomp, spec.Capabilities)
+		if err != nil {
+			log.Errorf("Compile seccomp profile error: %v", err)
+			return err
+		}
+		if err := seccomp.Install(filter); err != nil {
+			log.Errorf("Install seccomp filter error: %v", err)
+			return err
+		}
+	}
+	// 挂载等初始化操作完成后再丢弃 capability
+	if spec.Capabilities != nil {
+		if err := applyCapabilities(spec.Capabilities); err != nil {
+			log.Errorf("Apply capabilities error: %v", err)
+			return err
+		}
+	}
+	if spec.Init {
+		return runAsInit(path, cmdArray)
+	}
 	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
 		log.Errorf(err.Error())
 	}
@@ -41,45 +95,71 @@ func RunContainerInitProcess() error {
 // 在NewParentProcess中将pipe的读端设置为3号文件描述符
 // 在init进程中通过3号文件描述符获取管道读端, 设置pipe的Name为pipe
 // 等待父进程通过管道写入命令(阻塞) 也就是sendInitCommand函数的执行
-func readUserCommand() []string {
+func readUserCommand() *InitSpec {
 	pipe := os.NewFile(uintptr(3), "pipe")
 	msg, err := ioutil.ReadAll(pipe)
+	pipe.Close()
 	if err != nil {
 		log.Errorf("init read pipe error %v", err)
 		return nil
 	}
 
-	var cmdArray []string
-	// 将读取到的 JSON 数据还原为 []string 切片
-	if err := json.Unmarshal(msg, &cmdArray); err != nil {
+	var spec InitSpec
+	// 将读取到的 JSON 数据还原为 InitSpec
+	if err := json.Unmarshal(msg, &spec); err != nil {
 		log.Errorf("Unmarshal command error: %v", err)
 		return nil
 	}
-	return cmdArray
+	return &spec
 }
 
 // 将当前工作目录作为新的根文件系统 并挂载proc文件系统
-func setUpMount() {
+// proc 和 /dev 在 pivot_root 之前挂载到新的根文件系统中:
+// 在 user namespace 中只有当前 mount namespace 里存在完整可见的 proc 时才允许挂载新的 proc，
+// 并且不能 mknod，需要从宿主机的 /dev bind mount 设备文件，这两者在卸载旧的根文件系统之后都无法完成
+func setUpMount(spec *InitSpec) error {
+	// 容器的 mount namespace 复制自宿主机，挂载点默认可能是 shared 的，
+	// 先改为 private，避免容器内的挂载传播回宿主机，导致宿主机上的挂载点无法卸载
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("Make mounts private error: %v", err)
+	}
+
 	pwd, err := os.Getwd()
 	if err != nil {
-		log.Errorf("Get current location error %v", err)
-		return
+		return fmt.Errorf("Get current location error %v", err)
 	}
 	log.Infof("Current location is %s", pwd)
-	if err := pivotRoot(pwd); err != nil {
-		log.Errorf("pivot root error %v", err)
-		return
-	}
 
 	// 挂载 proc
 	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	if err := syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), ""); err != nil {
-		log.Errorf("Mount proc error: %v", err)
-		return
+	procDir := path.Join(pwd, "proc")
+	os.MkdirAll(procDir, 0555)
+	if err := syscall.Mount("proc", procDir, "proc", uintptr(defaultMountFlags), ""); err != nil {
+		return fmt.Errorf("Mount proc error: %v", err)
 	}
 
-	// 挂载 tmpfs 到 /dev
-	if err := syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755"); err != nil {
+	setUpDev(path.Join(pwd, "dev"))
+
+	if err := pivotRoot(pwd); err != nil {
+		return fmt.Errorf("pivot root error %v", err)
+	}
+
+	if err := maskPaths(defaultMaskedPaths); err != nil {
+		return err
+	}
+	if err := readonlyPaths(defaultReadonlyPaths); err != nil {
+		return err
+	}
+	if spec.ReadOnly {
+		return setUpReadOnlyRootfs()
+	}
+	return nil
+}
+
+// 挂载 tmpfs 到 /dev 并创建常用的设备文件
+func setUpDev(devDir string) {
+	os.MkdirAll(devDir, 0755)
+	if err := syscall.Mount("tmpfs", devDir, "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755"); err != nil {
 		log.Errorf("Mount tmpfs to /dev error: %v", err)
 		return
 	}
@@ -87,14 +167,47 @@ func setUpMount() {
 	// 关键：手动创建设备节点
 	// 必须要手动创建 /dev/null，否则 Nginx 等程序无法启动
 	// 参数说明: 路径, 权限|字符设备类型, 设备号(通过 makedev 计算)
-	// /dev/null 的主设备号是 1, 次设备号是 3
-	if err := syscall.Mknod("/dev/null", 0666|syscall.S_IFCHR, int(makedev(1, 3))); err != nil {
-		log.Errorf("Mknod /dev/null error: %v", err)
+	// /dev/null 的主设备号是 1, 次设备号是 3，/dev/zero 很多程序也需要 (1, 5)
+	devices := []struct {
+		name         string
+		major, minor uint32
+	}{
+		{"null", 1, 3},
+		{"zero", 1, 5},
+	}
+	for _, dev := range devices {
+		target := path.Join(devDir, dev.name)
+		err := syscall.Mknod(target, 0666|syscall.S_IFCHR, int(makedev(dev.major, dev.minor)))
+		if err == nil {
+			continue
+		}
+		if err != syscall.EPERM {
+			log.Errorf("Mknod %s error: %v", target, err)
+			continue
+		}
+		// user namespace 中没有权限创建设备文件，改为 bind mount 宿主机的设备文件
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			log.Errorf("Create %s error: %v", target, err)
+			continue
+		}
+		f.Close()
+		if err := syscall.Mount(path.Join("/dev", dev.name), target, "bind", syscall.MS_BIND, ""); err != nil {
+			log.Errorf("Bind mount /dev/%s error: %v", dev.name, err)
+		}
 	}
+}
 
-	// 建议顺便把 /dev/zero 也建了，很多程序需要它 (1, 5)
-	if err := syscall.Mknod("/dev/zero", 0666|syscall.S_IFCHR, int(makedev(1, 5))); err != nil {
-		log.Errorf("Mknod /dev/zero error: %v", err)
+// 启用容器 network namespace 中的回环网卡
+// 没有连接网络的容器(包括只能使用回环网络的 rootless 容器)也可以通过 127.0.0.1 通信
+func setUpLoopback() {
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		log.Errorf("Get loopback link error: %v", err)
+		return
+	}
+	if err := netlink.LinkSetUp(lo); err != nil {
+		log.Errorf("Set loopback link up error: %v", err)
 	}
 }
 
@@ -0,0 +1,96 @@
+// exp/sixDocker/container/inspect.go
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// 容器 overlay 各层的路径
+type LayerPaths struct {
+	LowerDir  string `json:"lowerDir"`  // 只读镜像层
+	UpperDir  string `json:"upperDir"`  // 可写层
+	WorkDir   string `json:"workDir"`   // overlay 工作目录
+	MergedDir string `json:"mergedDir"` // 挂载点
+}
+
+// 容器的数据卷挂载
+type Mount struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Mode        string `json:"mode"`
+	RW          bool   `json:"rw"`
+}
+
+// 镜像信息，镜像以 tar 包的形式保存在 IMAGEDIR 中
+type ImageInfo struct {
+	Name          string            `json:"name"`
+	Path          string            `json:"path"`
+	Size          int64             `json:"size"`
+	Created       string            `json:"created"`
+	ReadOnlyLayer string            `json:"readOnlyLayer,omitempty"` // 镜像被解压后的只读层目录
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+func GetLayerPaths(containerInfo *ContainerInfo) *LayerPaths {
+	containerDir := containerDir(containerInfo.Id)
+	ufsDir := path.Join(containerDir, "ufs")
+	return &LayerPaths{
+		LowerDir:  readOnlyLayerPath(containerInfo),
+		UpperDir:  path.Join(ufsDir, "writeLayer"),
+		WorkDir:   path.Join(ufsDir, "workLayer"),
+		MergedDir: path.Join(containerDir, "mnt"),
+	}
+}
+
+// 解析 -v 参数(source:target[:mode])
+func GetMounts(volumes []string) []Mount {
+	mounts := []Mount{}
+	for _, v := range volumes {
+		parts := strings.Split(v, ":")
+		if len(parts) < 2 {
+			continue
+		}
+		mode := "rw"
+		if len(parts) == 3 {
+			mode = parts[2]
+		}
+		mounts = append(mounts, Mount{
+			Source:      parts[0],
+			Destination: parts[1],
+			Mode:        mode,
+			RW:          mode != "ro",
+		})
+	}
+	return mounts
+}
+
+func GetImageInfo(imageName string) (*ImageInfo, error) {
+	imagePath := path.Join(IMAGEDIR, imageName+".tar")
+	stat, err := os.Stat(imagePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such image %s", imageName)
+		}
+		return nil, err
+	}
+	image := &ImageInfo{
+		Name:    imageName,
+		Path:    imagePath,
+		Size:    stat.Size(),
+		Created: stat.ModTime().Format(TimeFormat),
+	}
+	imageConfig, err := LoadImageConfig(imageName)
+	if err != nil {
+		return nil, err
+	}
+	image.Labels = imageConfig.Labels
+	readOnlyLayer := path.Join(READONLYLAYERDIR, imageName)
+	if _, err := os.Stat(readOnlyLayer); err == nil {
+		image.ReadOnlyLayer = readOnlyLayer
+	}
+	return image, nil
+}
@@ -0,0 +1,103 @@
+// exp/sixDocker/container/label.go
+
+package container
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sixDocker/fileutil"
+	"strings"
+)
+
+// 镜像的元数据，保存在 IMAGEDIR 中与镜像 tar 包同名的 json 文件中
+type ImageConfig struct {
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// 解析 --label-file 和 --label 参数，与 docker 相同，后面的文件覆盖前面文件中的同名 label，--label 覆盖所有文件
+// label 的格式为 key=value，只有 key 时值为空字符串
+func ParseLabels(labels []string, labelFiles []string) (map[string]string, error) {
+	result := map[string]string{}
+	var all []string
+	for _, labelFile := range labelFiles {
+		fileLabels, err := readLabelFile(labelFile)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, fileLabels...)
+	}
+	for _, label := range append(all, labels...) {
+		parts := strings.SplitN(label, "=", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("invalid label %q: key is empty", label)
+		}
+		if len(parts) == 1 {
+			result[parts[0]] = ""
+		} else {
+			result[parts[0]] = parts[1]
+		}
+	}
+	return result, nil
+}
+
+// label 文件每行一个 label，忽略空行和 # 开头的注释
+func readLabelFile(filePath string) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("open label file %s error: %v", filePath, err)
+	}
+	defer f.Close()
+
+	var labels []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		labels = append(labels, line)
+	}
+	return labels, scanner.Err()
+}
+
+// 读取镜像的元数据，没有元数据文件的镜像返回空配置
+func LoadImageConfig(imageName string) (*ImageConfig, error) {
+	config := &ImageConfig{}
+	content, err := os.ReadFile(path.Join(IMAGEDIR, imageName+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("unmarshal image %s config error: %v", imageName, err)
+	}
+	return config, nil
+}
+
+func saveImageConfig(imageName string, config *ImageConfig) error {
+	content, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomic(path.Join(IMAGEDIR, imageName+".json"), content, 0644)
+}
+
+// 合并镜像和容器的 label，容器的 label 优先
+func mergeLabels(imageLabels map[string]string, containerLabels map[string]string) map[string]string {
+	if len(imageLabels) == 0 && len(containerLabels) == 0 {
+		return nil
+	}
+	labels := map[string]string{}
+	for k, v := range imageLabels {
+		labels[k] = v
+	}
+	for k, v := range containerLabels {
+		labels[k] = v
+	}
+	return labels
+}
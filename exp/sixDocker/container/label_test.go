@@ -0,0 +1,64 @@
+// exp/sixDocker/container/label_test.go
+
+package container
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestReadLabelFile(t *testing.T) {
+	file := path.Join(t.TempDir(), "labels")
+	os.WriteFile(file, []byte("# comment\n\n  app=web  \nempty=\nflag\n  # indented comment\nurl=http://x/?a=b\n"), 0644)
+	labels, err := readLabelFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"app=web", "empty=", "flag", "url=http://x/?a=b"}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("readLabelFile = %q, want %q", labels, want)
+	}
+	if _, err := readLabelFile(path.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("expected error for missing label file")
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	dir := t.TempDir()
+	first, second, bad := path.Join(dir, "first"), path.Join(dir, "second"), path.Join(dir, "bad")
+	os.WriteFile(first, []byte("# first\napp=web\ntier=frontend\nonly.first=1\n"), 0644)
+	os.WriteFile(second, []byte("tier=backend\nonly.second=2\n"), 0644)
+	os.WriteFile(bad, []byte("=value\n"), 0644)
+
+	cases := []struct {
+		name   string
+		labels []string
+		files  []string
+		want   map[string]string
+		err    bool
+	}{
+		{"none", nil, nil, map[string]string{}, false},
+		{"cli", []string{"a=1", "b", "c=x=y"}, nil, map[string]string{"a": "1", "b": "", "c": "x=y"}, false},
+		{"later cli wins", []string{"a=1", "a=2"}, nil, map[string]string{"a": "2"}, false},
+		{"later file wins", nil, []string{first, second}, map[string]string{"app": "web", "tier": "backend", "only.first": "1", "only.second": "2"}, false},
+		{"file order", nil, []string{second, first}, map[string]string{"app": "web", "tier": "frontend", "only.first": "1", "only.second": "2"}, false},
+		{"cli overrides files", []string{"tier=cli"}, []string{first, second}, map[string]string{"app": "web", "tier": "cli", "only.first": "1", "only.second": "2"}, false},
+		{"empty key", []string{"=x"}, nil, nil, true},
+		{"empty key in file", nil, []string{bad}, nil, true},
+		{"missing file", nil, []string{path.Join(dir, "missing")}, nil, true},
+	}
+	for _, c := range cases {
+		got, err := ParseLabels(c.labels, c.files)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected error", c.name)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: ParseLabels = %v, %v, want %v", c.name, got, err, c.want)
+		}
+	}
+}
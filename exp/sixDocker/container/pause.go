@@ -0,0 +1,60 @@
+// exp/sixDocker/container/pause.go
+
+package container
+
+import (
+	"fmt"
+	"sixDocker/cgroups"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 通过 cgroup freezer 冻结容器中的所有进程
+func PauseContainer(containerName string) error {
+	containerInfo, err := GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Status == PAUSED {
+		return fmt.Errorf("container %s is already paused", containerName)
+	}
+	pid, _ := strconv.Atoi(containerInfo.Pid)
+	if containerInfo.Status != RUNNING || !isProcessRunning(pid, containerInfo.PidStartTime) {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+	// rootless 模式下没有可用的 cgroup 时无法使用 freezer
+	if containerInfo.CgroupPath == "" {
+		return fmt.Errorf("container %s has no cgroup, pause is not supported", containerName)
+	}
+	if err := cgroups.NewCgroupManager(containerInfo.CgroupPath).Freeze(); err != nil {
+		log.Errorf("Freeze container %s error: %v", containerName, err)
+		return err
+	}
+	return setContainerStatus(containerInfo.Id, PAUSED)
+}
+
+// 解冻被 pause 的容器
+func UnpauseContainer(containerName string) error {
+	containerInfo, err := GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Status != PAUSED {
+		return fmt.Errorf("container %s is not paused", containerName)
+	}
+	if err := cgroups.NewCgroupManager(containerInfo.CgroupPath).Thaw(); err != nil {
+		log.Errorf("Thaw container %s error: %v", containerName, err)
+		return err
+	}
+	return setContainerStatus(containerInfo.Id, RUNNING)
+}
+
+// 在锁内修改容器状态，保留其他命令在此期间对容器信息做的修改
+func setContainerStatus(containerId string, status string) error {
+	_, err := UpdateContainerInfo(containerId, func(latest *ContainerInfo) error {
+		latest.Status = status
+		return nil
+	})
+	return err
+}
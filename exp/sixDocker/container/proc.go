@@ -0,0 +1,127 @@
+// exp/sixDocker/container/proc.go
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 判断进程是否存活
+// kill -0 对僵尸进程同样返回成功，因此还需要检查 /proc/<pid>/stat 中的进程状态
+func isProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// stat 格式: pid (comm) state ...，comm 中可能包含空格，因此从最后一个 ')' 之后开始解析
+	stat := string(content)
+	idx := strings.LastIndex(stat, ")")
+	if idx < 0 || idx+2 >= len(stat) {
+		return false
+	}
+	return stat[idx+2] != 'Z'
+}
+
+// 读取进程的启动时间(/proc/<pid>/stat 第 22 个字段，单位为系统启动后的时钟滴答数)
+// 进程号可能被复用，进程号和启动时间一起才能唯一确定一个进程，读取失败返回 0
+func ProcessStartTime(pid int) uint64 {
+	if pid <= 0 {
+		return 0
+	}
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0
+	}
+	return parseStartTime(string(content))
+}
+
+// 解析 /proc/<pid>/stat 中的第 22 个字段 starttime
+// comm 中可能包含空格和括号，从最后一个 ")" 之后的 state 字段(第 3 个字段)开始计数
+func parseStartTime(stat string) uint64 {
+	idx := strings.LastIndex(stat, ")")
+	if idx < 0 {
+		return 0
+	}
+	fields := strings.Fields(stat[idx+1:])
+	if len(fields) < 20 {
+		return 0
+	}
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return startTime
+}
+
+// 判断记录的进程是否仍在运行: 进程存活且启动时间与记录一致
+// 宿主机重启后进程号可能已经被其他进程占用，只比较进程号会误判
+// 没有记录启动时间(旧版本创建的容器)时只检查进程号
+func isProcessRunning(pid int, startTime uint64) bool {
+	if !isProcessAlive(pid) {
+		return false
+	}
+	return startTime == 0 || ProcessStartTime(pid) == startTime
+}
+
+// 等待进程退出，超时返回 false
+func waitProcessExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for isProcessAlive(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+// 判断路径是否是挂载点
+// mountinfo 中记录的是解析符号链接之后的路径(例如 /var/run 指向 /run)，比较前先解析 target
+func isMountPoint(target string) bool {
+	if resolved, err := filepath.EvalSymlinks(target); err == nil {
+		target = resolved
+	}
+	content, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		// [mount ID, parent mount ID, major:minor, root, mount point, ...]
+		fields := strings.Fields(line)
+		if len(fields) > 4 && unescapeMountPath(fields[4]) == target {
+			return true
+		}
+	}
+	return false
+}
+
+// mountinfo 中路径里的空格、制表符、换行和反斜杠被转义为 \040 形式的八进制
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
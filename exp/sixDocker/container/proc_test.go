@@ -0,0 +1,42 @@
+// exp/sixDocker/container/proc_test.go
+
+package container
+
+import (
+	"os"
+	"testing"
+)
+
+func TestUnescapeMountPath(t *testing.T) {
+	cases := map[string]string{
+		"/var/lib/sixDocker/mnt": "/var/lib/sixDocker/mnt",
+		`/home/me/my\040data`:    "/home/me/my data",
+		`/a\011b\012c\134d`:      "/a\tb\nc\\d",
+		`/trailing\04`:           `/trailing\04`,
+		`/not\9octal`:            `/not\9octal`,
+	}
+	for in, want := range cases {
+		if got := unescapeMountPath(in); got != want {
+			t.Errorf("unescapeMountPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseStartTime(t *testing.T) {
+	cases := map[string]uint64{
+		"1234 (sleep) S 1 1234 1234 0 -1 4194560 100 0 0 0 0 0 0 0 20 0 1 0 987654 2375680 129 18446744073709551615": 987654,
+		"42 (my (odd) proc) R 1 42 42 0 -1 4194304 0 0 0 0 0 0 0 0 20 0 1 0 31337 0 0 18446744073709551615":          31337,
+		"42 (a b) Z 1 42 42 0 -1 4194304 0 0 0 0 0 0 0 0 20 0 1 0 77 0 0":                                            77,
+		"42 (short) S 1 2 3":  0,
+		"no parenthesis here": 0,
+		"42 (bad) S 1 42 42 0 -1 4194304 0 0 0 0 0 0 0 0 20 0 1 0 notanumber 0 0": 0,
+	}
+	for stat, want := range cases {
+		if got := parseStartTime(stat); got != want {
+			t.Errorf("parseStartTime(%q) = %d, want %d", stat, got, want)
+		}
+	}
+	if ProcessStartTime(os.Getpid()) == 0 {
+		t.Errorf("ProcessStartTime of the current process is 0")
+	}
+}
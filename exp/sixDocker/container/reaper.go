@@ -0,0 +1,71 @@
+// exp/sixDocker/container/reaper.go
+
+package container
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// --init 模式: init 进程保持为容器内的 1 号进程，fork 出用户命令，
+// 把收到的信号转发给它，回收所有孤儿进程，并以用户命令的退出状态退出
+func runAsInit(path string, args []string) error {
+	// 必须在启动子进程之前注册，否则子进程很快退出时会丢失 SIGCHLD
+	sigs := make(chan os.Signal, 128)
+	signal.Notify(sigs)
+
+	cmd := exec.Command(path)
+	cmd.Args = args
+	cmd.Env = os.Environ()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		log.Errorf("Start user command error %v", err)
+		return err
+	}
+	childPid := cmd.Process.Pid
+	log.Infof("User command started as pid %d", childPid)
+
+	for sig := range sigs {
+		switch sig {
+		case syscall.SIGCHLD:
+			if exitCode, exited := reapChildren(childPid); exited {
+				os.Exit(exitCode)
+			}
+		case syscall.SIGURG:
+			// SIGURG 被 Go 运行时用于抢占调度，不转发
+		default:
+			if err := syscall.Kill(childPid, sig.(syscall.Signal)); err != nil && err != syscall.ESRCH {
+				log.Warnf("Forward signal %v to pid %d error %v", sig, childPid, err)
+			}
+		}
+	}
+	return nil
+}
+
+// 回收所有已经退出的子进程（包括被托管给 1 号进程的孤儿进程），
+// 如果用户命令本身已退出则返回它的退出码
+func reapChildren(childPid int) (int, bool) {
+	exitCode, exited := 0, false
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err != nil || pid <= 0 {
+			return exitCode, exited
+		}
+		if pid != childPid {
+			continue
+		}
+		exited = true
+		if status.Signaled() {
+			exitCode = 128 + int(status.Signal())
+		} else {
+			exitCode = status.ExitStatus()
+		}
+	}
+}
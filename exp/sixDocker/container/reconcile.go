@@ -0,0 +1,68 @@
+// exp/sixDocker/container/reconcile.go
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 宿主机重启或 monitor 被杀死后，无法得知容器进程真实的退出码
+const reconciledExitCode = 255
+
+// 检查记录为运行中的容器，容器进程和等待它的进程都已经不存在时(例如宿主机重启、run 或 monitor 进程崩溃)
+// 将容器标记为已退出。返回 true 表示容器被重新标记，调用方需要回收容器残留的运行时资源
+func ReconcileContainer(containerId string) (*ContainerInfo, bool, error) {
+	stale := false
+	containerInfo, err := UpdateContainerInfo(containerId, func(latest *ContainerInfo) error {
+		switch latest.Status {
+		case RUNNING, PAUSED, RESTARTING:
+		default:
+			return nil
+		}
+		if IsContainerRunning(latest) {
+			return nil
+		}
+		stale = true
+		log.Infof("Container %s (pid: %s) is no longer running, marking it as exited", latest.Name, latest.Pid)
+		latest.Status = EXIT
+		latest.Pid = ""
+		latest.ExitCode = reconciledExitCode
+		latest.Error = "container process exited while no sixDocker process was waiting for it"
+		latest.FinishedAt = time.Now().Format(TimeFormat)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return containerInfo, stale, nil
+}
+
+// 删除指向不存在的容器的名称索引，返回被删除的名称
+func PruneContainerNames() ([]string, error) {
+	dirURL := fmt.Sprintf(NameIndexLocation, "")
+	files, err := os.ReadDir(dirURL)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pruned []string
+	for _, file := range files {
+		containerId, ok := lookupContainerName(file.Name())
+		if ok && checkContainerExists(containerId) {
+			continue
+		}
+		log.Infof("Removing stale name index %s", file.Name())
+		if err := releaseContainerName(file.Name()); err != nil {
+			log.Errorf("Remove name index %s error: %v", file.Name(), err)
+			continue
+		}
+		pruned = append(pruned, file.Name())
+	}
+	return pruned, nil
+}
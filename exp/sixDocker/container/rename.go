@@ -0,0 +1,36 @@
+// exp/sixDocker/container/rename.go
+
+package container
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 重命名容器，容器目录、日志和挂载点都以容器 ID 命名，只需要更新名称索引和 config.json
+func RenameContainer(containerName string, newName string) error {
+	containerInfo, err := GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	oldName := containerInfo.Name
+	if oldName == newName {
+		return fmt.Errorf("renaming a container with the same name as its current name")
+	}
+	if err := reserveContainerName(newName, containerInfo.Id); err != nil {
+		return err
+	}
+	if _, err := UpdateContainerInfo(containerInfo.Id, func(latest *ContainerInfo) error {
+		latest.Name = newName
+		return nil
+	}); err != nil {
+		releaseContainerName(newName)
+		return err
+	}
+	if err := releaseContainerName(oldName); err != nil {
+		log.Warnf("Release container name %s error: %v", oldName, err)
+	}
+	log.Infof("Container %s renamed to %s", oldName, newName)
+	return nil
+}
@@ -0,0 +1,76 @@
+// exp/sixDocker/container/restart.go
+
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	RestartNo            = "no"
+	RestartOnFailure     = "on-failure"
+	RestartAlways        = "always"
+	RestartUnlessStopped = "unless-stopped"
+)
+
+// 容器的重启策略，由等待容器进程退出的进程(monitor 或 -ti 模式下的 run 进程)执行
+type RestartPolicy struct {
+	Name              string `json:"name"`              // no | on-failure | always | unless-stopped
+	MaximumRetryCount int    `json:"maximumRetryCount"` // on-failure 的最大重启次数，0 表示不限制
+}
+
+// 解析 --restart 参数: no | on-failure[:N] | always | unless-stopped
+func ParseRestartPolicy(policy string) (RestartPolicy, error) {
+	if policy == "" {
+		return RestartPolicy{Name: RestartNo}, nil
+	}
+	parts := strings.SplitN(policy, ":", 2)
+	restartPolicy := RestartPolicy{Name: parts[0]}
+	switch restartPolicy.Name {
+	case RestartNo, RestartAlways, RestartUnlessStopped:
+		if len(parts) == 2 {
+			return restartPolicy, fmt.Errorf("maximum retry count cannot be used with restart policy %s", parts[0])
+		}
+	case RestartOnFailure:
+		if len(parts) == 2 {
+			count, err := strconv.Atoi(parts[1])
+			if err != nil || count < 0 {
+				return restartPolicy, fmt.Errorf("invalid maximum retry count %s", parts[1])
+			}
+			restartPolicy.MaximumRetryCount = count
+		}
+	default:
+		return restartPolicy, fmt.Errorf("invalid restart policy %s", policy)
+	}
+	return restartPolicy, nil
+}
+
+func (p RestartPolicy) String() string {
+	if p.Name == RestartOnFailure && p.MaximumRetryCount > 0 {
+		return fmt.Sprintf("%s:%d", p.Name, p.MaximumRetryCount)
+	}
+	if p.Name == "" {
+		return RestartNo
+	}
+	return p.Name
+}
+
+// 根据重启策略判断容器进程退出后是否需要重启
+// 被 stop 停止的容器不会重启，因此 always 和 unless-stopped 在这里的行为一致
+func (p RestartPolicy) ShouldRestart(containerInfo *ContainerInfo) bool {
+	if containerInfo.ManuallyStopped {
+		return false
+	}
+	switch p.Name {
+	case RestartAlways, RestartUnlessStopped:
+		return true
+	case RestartOnFailure:
+		if containerInfo.ExitCode == 0 {
+			return false
+		}
+		return p.MaximumRetryCount == 0 || containerInfo.RestartCount < p.MaximumRetryCount
+	}
+	return false
+}
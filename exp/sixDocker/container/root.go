@@ -0,0 +1,22 @@
+// exp/sixDocker/container/root.go
+
+package container
+
+import (
+	"path"
+)
+
+// 默认的状态根目录，容器、镜像、只读层和网络状态都保存在根目录下
+const DefaultRoot = "/var/run/sixDocker"
+
+// 当前使用的状态根目录
+var RootDir = DefaultRoot
+
+// 把容器、名称索引、镜像和只读层目录设置到 root 下
+func SetRoot(root string) {
+	RootDir = root
+	IMAGEDIR = path.Join(root, "images")
+	READONLYLAYERDIR = path.Join(root, "readOnlyLayer")
+	DefaultInfoLocation = path.Join(root, "containers") + "/%s"
+	NameIndexLocation = path.Join(root, "names") + "/%s"
+}
@@ -0,0 +1,153 @@
+// exp/sixDocker/container/rootless.go
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"sixDocker/cgroups/subsystems"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 以普通用户运行 sixDocker 时为 true
+// rootless 模式下 sixDocker 先进入以当前用户为 root 的 user namespace，再在其中创建容器
+var Rootless bool
+
+// rootless 模式下外层 user namespace 的 uid/gid 映射:
+// 容器内的 root 映射为当前用户，/etc/subuid、/etc/subgid 中有当前用户的子 ID 并且安装了 newuidmap/newgidmap 时，
+// 再把子 ID 依次映射为容器内的 1、2、3...，否则只映射 root 一个 ID
+func RootlessIDMappings() ([]IDMap, []IDMap) {
+	uidMaps := []IDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	gidMaps := []IDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	if _, err := exec.LookPath("newuidmap"); err != nil {
+		return uidMaps, gidMaps
+	}
+	if _, err := exec.LookPath("newgidmap"); err != nil {
+		return uidMaps, gidMaps
+	}
+	u, err := user.Current()
+	if err != nil {
+		return uidMaps, gidMaps
+	}
+	subUids, err := readSubIDs(subuidFile, u.Username, u.Uid)
+	if err != nil {
+		return uidMaps, gidMaps
+	}
+	subGids, err := readSubIDs(subgidFile, u.Username, u.Uid)
+	if err != nil {
+		return uidMaps, gidMaps
+	}
+	for _, m := range subUids {
+		uidMaps = append(uidMaps, IDMap{ContainerID: m.ContainerID + 1, HostID: m.HostID, Size: m.Size})
+	}
+	for _, m := range subGids {
+		gidMaps = append(gidMaps, IDMap{ContainerID: m.ContainerID + 1, HostID: m.HostID, Size: m.Size})
+	}
+	return uidMaps, gidMaps
+}
+
+// 为刚创建了 user namespace 的进程写入 uid/gid 映射
+// 普通用户只能直接映射自己的 uid/gid(并且需要先禁用 setgroups)，映射子 ID 需要借助 setuid 的 newuidmap/newgidmap
+func WriteIDMappings(pid int, uidMaps []IDMap, gidMaps []IDMap) error {
+	if len(uidMaps) > 1 || len(gidMaps) > 1 {
+		if err := runIDMapTool("newuidmap", pid, uidMaps); err != nil {
+			return err
+		}
+		return runIDMapTool("newgidmap", pid, gidMaps)
+	}
+	procDir := fmt.Sprintf("/proc/%d", pid)
+	if err := os.WriteFile(path.Join(procDir, "uid_map"), []byte(formatIDMaps(uidMaps)), 0644); err != nil {
+		return fmt.Errorf("write uid_map error: %v", err)
+	}
+	if err := os.WriteFile(path.Join(procDir, "setgroups"), []byte("deny"), 0644); err != nil {
+		return fmt.Errorf("write setgroups error: %v", err)
+	}
+	if err := os.WriteFile(path.Join(procDir, "gid_map"), []byte(formatIDMaps(gidMaps)), 0644); err != nil {
+		return fmt.Errorf("write gid_map error: %v", err)
+	}
+	return nil
+}
+
+func runIDMapTool(tool string, pid int, maps []IDMap) error {
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range maps {
+		args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+	if output, err := exec.Command(tool, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s error: %v, output: %s", tool, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func formatIDMaps(maps []IDMap) string {
+	var lines []string
+	for _, m := range maps {
+		lines = append(lines, fmt.Sprintf("%d %d %d", m.ContainerID, m.HostID, m.Size))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// 当前 user namespace 中只映射了一个 uid 时，无法把文件的属主改为其他用户
+func singleIDMapped() bool {
+	content, err := os.ReadFile("/proc/self/uid_map")
+	if err != nil {
+		return false
+	}
+	total := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 {
+			size, _ := strconv.Atoi(fields[2])
+			total += size
+		}
+	}
+	return total == 1
+}
+
+// 容器目录默认不可执行，只有 root 凭借 CAP_DAC_OVERRIDE 才能进入
+// rootless 模式下 exec 在宿主机的 user namespace 中以普通用户身份读取容器信息，需要允许属主进入容器目录
+func allowRootlessHostAccess(dir string) error {
+	if !Rootless {
+		return nil
+	}
+	if err := os.Chmod(path.Dir(dir), 0700); err != nil {
+		return err
+	}
+	return os.Chmod(dir, 0700)
+}
+
+// 查找委派给当前用户的 cgroup v2 子树，测试中可以替换
+var delegatedCgroupV2Path = subsystems.DelegatedCgroupV2Path
+
+// 容器的 cgroup 路径(相对于 cgroup 根目录)，为空表示不使用 cgroup
+// rootless 模式下只能在委派给当前用户的 cgroup v2 子树中创建 cgroup
+// 没有可用的子树时，设置了资源限制的容器无法运行，没有资源限制的容器不使用 cgroup
+func ContainerCgroupPath(containerInfo *ContainerInfo) (string, error) {
+	cgroupName := "sixDocker-" + containerInfo.Id
+	if !Rootless {
+		return cgroupName, nil
+	}
+	delegated, err := delegatedCgroupV2Path()
+	if err != nil {
+		if hasResourceLimits(containerInfo.ResourceConfig) {
+			log.Errorf("Rootless container %s has resource limits but no delegated cgroup: %v", containerInfo.Id, err)
+			return "", fmt.Errorf("resource limits require a delegated cgroup v2 subtree in rootless mode: %v", err)
+		}
+		log.Warnf("Rootless container %s runs without cgroup: %v", containerInfo.Id, err)
+		return "", nil
+	}
+	return path.Join(delegated, cgroupName), nil
+}
+
+func hasResourceLimits(res *subsystems.ResourceConfig) bool {
+	if res == nil {
+		return false
+	}
+	return res.MemoryLimit != "" || res.CpuShare != "" || res.CpuSet != "" || res.CpuQuota != ""
+}
@@ -0,0 +1,61 @@
+// exp/sixDocker/container/rootless_test.go
+
+package container
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"sixDocker/cgroups/subsystems"
+)
+
+func TestFormatIDMaps(t *testing.T) {
+	maps := []IDMap{{0, 1000, 1}, {1, 100000, 65536}}
+	if got, want := formatIDMaps(maps), "0 1000 1\n1 100000 65536"; got != want {
+		t.Errorf("formatIDMaps = %q, want %q", got, want)
+	}
+}
+
+func TestContainerCgroupPath(t *testing.T) {
+	oldRootless, oldDelegated := Rootless, delegatedCgroupV2Path
+	defer func() {
+		Rootless, delegatedCgroupV2Path = oldRootless, oldDelegated
+	}()
+	info := &ContainerInfo{Id: "abc"}
+	limited := &ContainerInfo{Id: "abc", ResourceConfig: &subsystems.ResourceConfig{MemoryLimit: "100m"}}
+
+	Rootless = false
+	if got, err := ContainerCgroupPath(info); err != nil || got != "sixDocker-abc" {
+		t.Errorf("ContainerCgroupPath = %q, %v, want sixDocker-abc", got, err)
+	}
+
+	// 伪造 /proc/self/cgroup 和 cgroup 层级，当前进程所在的 user@1000.service 委派给当前用户
+	Rootless = true
+	cgroupRoot := t.TempDir()
+	delegated := "user.slice/user-1000.slice/user@1000.service"
+	if err := os.MkdirAll(path.Join(cgroupRoot, delegated), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(path.Join(cgroupRoot, delegated, "cgroup.subtree_control"), nil, 0644)
+	os.WriteFile(path.Join(cgroupRoot, delegated, "cgroup.procs"), nil, 0644)
+	procCgroup := path.Join(t.TempDir(), "cgroup")
+	delegatedCgroupV2Path = func() (string, error) {
+		return subsystems.DelegatedCgroupV2PathIn(cgroupRoot, procCgroup)
+	}
+
+	os.WriteFile(procCgroup, []byte("0::/"+delegated+"\n"), 0644)
+	want := "/" + delegated + "/sixDocker-abc"
+	if got, err := ContainerCgroupPath(limited); err != nil || got != want {
+		t.Errorf("ContainerCgroupPath = %q, %v, want %q", got, err, want)
+	}
+
+	// 没有委派的子树时，没有资源限制的容器不使用 cgroup，有资源限制的容器报错
+	os.WriteFile(procCgroup, []byte("0::/user.slice\n"), 0644)
+	if got, err := ContainerCgroupPath(info); err != nil || got != "" {
+		t.Errorf("ContainerCgroupPath = %q, %v, want empty path", got, err)
+	}
+	if got, err := ContainerCgroupPath(limited); err == nil {
+		t.Errorf("expected error for resource limits without delegated cgroup, got %q", got)
+	}
+}
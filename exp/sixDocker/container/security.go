@@ -0,0 +1,166 @@
+// exp/sixDocker/container/security.go
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sixDocker/seccomp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 与 docker 相同，默认屏蔽的路径: 文件用 /dev/null 覆盖，目录用只读的空 tmpfs 覆盖
+var defaultMaskedPaths = []string{
+	"/proc/acpi",
+	"/proc/asound",
+	"/proc/interrupts",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/proc/sysrq-trigger",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/sys/devices/virtual/powercap",
+	"/sys/firmware",
+}
+
+// 默认以只读方式挂载的路径
+var defaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+}
+
+// --security-opt 解析后的安全配置
+type SecurityOptions struct {
+	// 为 nil 时不限制系统调用
+	Seccomp *seccomp.Profile
+	// 禁止容器进程通过 setuid 程序或文件 capability 获得更多权限
+	NoNewPrivileges bool
+}
+
+// 解析 --security-opt，支持:
+//
+//	seccomp=unconfined      不使用 seccomp
+//	seccomp=PROFILE.json    使用 docker 格式的 seccomp 配置文件
+//	no-new-privileges[=true|false]
+//
+// 没有指定 seccomp 时使用默认配置，当前架构不支持 seccomp 时不限制系统调用
+func ParseSecurityOpts(opts []string) (*SecurityOptions, error) {
+	security := &SecurityOptions{}
+	seccompSet := false
+	for _, opt := range opts {
+		key, value, found := strings.Cut(opt, "=")
+		if !found {
+			// 兼容 docker 旧版本的 key:value 形式
+			key, value, found = strings.Cut(opt, ":")
+		}
+		switch {
+		case key == "no-new-privileges" && !found:
+			security.NoNewPrivileges = true
+		case key == "no-new-privileges":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --security-opt: %s", opt)
+			}
+			security.NoNewPrivileges = enabled
+		case key == "seccomp" && found && value == "unconfined":
+			security.Seccomp = nil
+			seccompSet = true
+		case key == "seccomp" && found && value != "":
+			profile, err := seccomp.LoadProfile(value)
+			if err != nil {
+				return nil, err
+			}
+			security.Seccomp = profile
+			seccompSet = true
+		default:
+			return nil, fmt.Errorf("invalid --security-opt: %s", opt)
+		}
+	}
+	if !seccompSet {
+		if seccomp.Supported() {
+			security.Seccomp = seccomp.DefaultProfile()
+		} else {
+			log.Warnf("Seccomp is not supported on %s, running container without seccomp", runtime.GOARCH)
+		}
+	}
+	return security, nil
+}
+
+// 屏蔽容器内不应该访问的路径，不存在的路径跳过
+func maskPaths(paths []string) error {
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Stat %s error: %v", p, err)
+		}
+		if info.IsDir() {
+			err = syscall.Mount("tmpfs", p, "tmpfs", syscall.MS_RDONLY, "size=0")
+		} else {
+			err = syscall.Mount("/dev/null", p, "", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("Mask %s error: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// 把路径 bind mount 到自身后重新挂载为只读，不存在的路径跳过
+func readonlyPaths(paths []string) error {
+	for _, p := range paths {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			continue
+		}
+		if err := syscall.Mount(p, p, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("Bind mount %s error: %v", p, err)
+		}
+		if err := remountReadOnly(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 重新挂载为只读，需要保留原有的 nosuid、nodev、noexec 等标志，
+// 否则在 user namespace 中会因为试图清除被锁定的标志而失败
+func remountReadOnly(target string) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(target, &st); err != nil {
+		return fmt.Errorf("Statfs %s error: %v", target, err)
+	}
+	// statfs 返回的 ST_* 标志与对应的 MS_* 取值相同
+	keep := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME)
+	flags := uintptr(st.Flags)&keep | syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY
+	if err := syscall.Mount("", target, "", flags, ""); err != nil {
+		return fmt.Errorf("Remount %s read-only error: %v", target, err)
+	}
+	return nil
+}
+
+// 只读的根文件系统: /tmp 和 /run 挂载 tmpfs 以便程序写入临时文件，然后把根目录重新挂载为只读
+// /dev、/proc 以及 -v 挂载的卷是独立的挂载点，不受影响
+func setUpReadOnlyRootfs() error {
+	for _, dir := range []string{"/tmp", "/run"} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("Mkdir %s error: %v", dir, err)
+		}
+		if err := syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+			return fmt.Errorf("Mount tmpfs to %s error: %v", dir, err)
+		}
+	}
+	log.Infof("Remount rootfs read-only")
+	return remountReadOnly("/")
+}
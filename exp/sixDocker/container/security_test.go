@@ -0,0 +1,44 @@
+// exp/sixDocker/container/security_test.go
+
+package container
+
+import (
+	"os"
+	"path"
+	"sixDocker/seccomp"
+	"testing"
+)
+
+func TestParseSecurityOpts(t *testing.T) {
+	security, err := ParseSecurityOpts(nil)
+	if err != nil || (security.Seccomp != nil) != seccomp.Supported() || security.NoNewPrivileges {
+		t.Fatalf("default security options = %+v, %v", security, err)
+	}
+
+	security, err = ParseSecurityOpts([]string{"seccomp=unconfined", "no-new-privileges"})
+	if err != nil || security.Seccomp != nil || !security.NoNewPrivileges {
+		t.Errorf("security options = %+v, %v", security, err)
+	}
+	security, err = ParseSecurityOpts([]string{"no-new-privileges=false", "seccomp:unconfined"})
+	if err != nil || security.Seccomp != nil || security.NoNewPrivileges {
+		t.Errorf("security options = %+v, %v", security, err)
+	}
+
+	profile := path.Join(t.TempDir(), "profile.json")
+	os.WriteFile(profile, []byte(`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["reboot"], "action": "SCMP_ACT_ERRNO"}]}`), 0644)
+	security, err = ParseSecurityOpts([]string{"seccomp=" + profile})
+	if !seccomp.Supported() {
+		// 不支持 seccomp 的架构上显式指定配置文件时报错
+		if err == nil {
+			t.Errorf("expected error for seccomp profile on unsupported architecture")
+		}
+	} else if err != nil || security.Seccomp == nil || len(security.Seccomp.Syscalls) != 1 {
+		t.Errorf("security options = %+v, %v", security, err)
+	}
+
+	for _, opt := range []string{"seccomp", "seccomp=", "no-new-privileges=maybe", "apparmor=unconfined"} {
+		if _, err := ParseSecurityOpts([]string{opt}); err == nil {
+			t.Errorf("expected error for %s", opt)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// exp/sixDocker/container/signal.go
+
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 默认的停止信号和等待容器退出的时间(秒)
+const (
+	DefaultStopSignal  = "SIGTERM"
+	DefaultStopTimeout = 10
+)
+
+var signalMap = map[string]syscall.Signal{
+	"ABRT":   syscall.SIGABRT,
+	"ALRM":   syscall.SIGALRM,
+	"BUS":    syscall.SIGBUS,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"FPE":    syscall.SIGFPE,
+	"HUP":    syscall.SIGHUP,
+	"ILL":    syscall.SIGILL,
+	"INT":    syscall.SIGINT,
+	"IO":     syscall.SIGIO,
+	"KILL":   syscall.SIGKILL,
+	"PIPE":   syscall.SIGPIPE,
+	"PROF":   syscall.SIGPROF,
+	"PWR":    syscall.SIGPWR,
+	"QUIT":   syscall.SIGQUIT,
+	"SEGV":   syscall.SIGSEGV,
+	"STKFLT": syscall.SIGSTKFLT,
+	"STOP":   syscall.SIGSTOP,
+	"SYS":    syscall.SIGSYS,
+	"TERM":   syscall.SIGTERM,
+	"TRAP":   syscall.SIGTRAP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"USR1":   syscall.SIGUSR1,
+	"USR2":   syscall.SIGUSR2,
+	"VTALRM": syscall.SIGVTALRM,
+	"WINCH":  syscall.SIGWINCH,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+}
+
+// 解析信号，支持 SIGTERM、TERM、15 三种写法
+func ParseSignal(rawSignal string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(rawSignal); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, fmt.Errorf("invalid signal %s", rawSignal)
+		}
+		return syscall.Signal(n), nil
+	}
+	sig, ok := signalMap[strings.TrimPrefix(strings.ToUpper(rawSignal), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("invalid signal %s", rawSignal)
+	}
+	return sig, nil
+}
+
+// 向运行中的容器 init 进程发送信号
+func KillContainer(containerName string, rawSignal string) error {
+	sig, err := ParseSignal(rawSignal)
+	if err != nil {
+		return err
+	}
+	containerInfo, err := GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Status == PAUSED {
+		return fmt.Errorf("container %s is paused, unpause the container before killing it", containerName)
+	}
+	pid, _ := strconv.Atoi(containerInfo.Pid)
+	if containerInfo.Status != RUNNING || !isProcessRunning(pid, containerInfo.PidStartTime) {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+	if err := syscall.Kill(pid, sig); err != nil {
+		return fmt.Errorf("send signal %s to container %s error: %v", rawSignal, containerName, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// exp/sixDocker/container/status.go
+
+package container
+
+import (
+	"fmt"
+	"time"
+)
+
+// 生成 ps 中展示的状态，例如 "Up 3 minutes"、"Exited (137) 3 minutes ago"
+func statusText(containerInfo *ContainerInfo) string {
+	now := time.Now()
+	switch containerInfo.Status {
+	case CREATED:
+		return "Created"
+	case RUNNING:
+		if startedAt, err := parseTime(containerInfo.StartedAt); err == nil {
+			return "Up " + humanDuration(now.Sub(startedAt))
+		}
+	case PAUSED:
+		if startedAt, err := parseTime(containerInfo.StartedAt); err == nil {
+			return "Up " + humanDuration(now.Sub(startedAt)) + " (Paused)"
+		}
+	case RESTARTING:
+		if finishedAt, err := parseTime(containerInfo.FinishedAt); err == nil {
+			return fmt.Sprintf("Restarting (%d) %s ago", containerInfo.ExitCode, humanDuration(now.Sub(finishedAt)))
+		}
+	case STOPPED, EXIT:
+		if finishedAt, err := parseTime(containerInfo.FinishedAt); err == nil {
+			status := fmt.Sprintf("Exited (%d) %s ago", containerInfo.ExitCode, humanDuration(now.Sub(finishedAt)))
+			if containerInfo.OOMKilled {
+				status += " (OOMKilled)"
+			}
+			return status
+		}
+	}
+	// 旧版本创建的容器没有记录时间，直接展示状态
+	return containerInfo.Status
+}
+
+func parseTime(value string) (time.Time, error) {
+	return time.ParseInLocation(TimeFormat, value, time.Local)
+}
+
+// 将时间间隔转换为便于阅读的描述
+func humanDuration(d time.Duration) string {
+	seconds := int(d.Seconds())
+	switch {
+	case seconds < 1:
+		return "Less than a second"
+	case seconds == 1:
+		return "1 second"
+	case seconds < 60:
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+	minutes := int(d.Minutes())
+	switch {
+	case minutes == 1:
+		return "About a minute"
+	case minutes < 60:
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	hours := int(d.Hours() + 0.5)
+	switch {
+	case hours == 1:
+		return "About an hour"
+	case hours < 48:
+		return fmt.Sprintf("%d hours", hours)
+	case hours < 24*7*2:
+		return fmt.Sprintf("%d days", hours/24)
+	case hours < 24*30*2:
+		return fmt.Sprintf("%d weeks", hours/24/7)
+	case hours < 24*365*2:
+		return fmt.Sprintf("%d months", hours/24/30)
+	}
+	return fmt.Sprintf("%d years", hours/24/365)
+}
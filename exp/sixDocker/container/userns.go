@@ -0,0 +1,336 @@
+// exp/sixDocker/container/userns.go
+
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 子 ID 文件，格式为 name:start:count，name 也可以是数字形式的 uid/gid
+var (
+	subuidFile = "/etc/subuid"
+	subgidFile = "/etc/subgid"
+)
+
+// 容器内的 ID 区间 [ContainerID, ContainerID+Size) 映射到宿主机上的 [HostID, HostID+Size)
+type IDMap struct {
+	ContainerID int `json:"containerId"`
+	HostID      int `json:"hostId"`
+	Size        int `json:"size"`
+}
+
+// 解析 --userns-remap 参数，返回 uid 和 gid 的映射
+// 支持两种形式:
+//
+//	USER[:GROUP]                       从 /etc/subuid 和 /etc/subgid 中读取该用户(组)的子 ID 区间，GROUP 默认与 USER 相同
+//	CONTAINERID:HOSTID:SIZE[,...]      直接指定映射区间，uid 和 gid 使用相同的映射
+func ParseUsernsRemap(remap string) ([]IDMap, []IDMap, error) {
+	if remap == "" {
+		return nil, nil, nil
+	}
+	if maps, ok, err := parseIDMaps(remap); ok {
+		if err != nil {
+			return nil, nil, err
+		}
+		return maps, maps, nil
+	}
+
+	userName, groupName, found := strings.Cut(remap, ":")
+	if !found {
+		groupName = userName
+	}
+	uidMaps, err := readSubIDs(subuidFile, userName, lookupUserID(userName))
+	if err != nil {
+		return nil, nil, err
+	}
+	gidMaps, err := readSubIDs(subgidFile, groupName, lookupGroupID(groupName))
+	if err != nil {
+		return nil, nil, err
+	}
+	return uidMaps, gidMaps, nil
+}
+
+// 解析 CONTAINERID:HOSTID:SIZE[,...]，不是这种形式时 ok 返回 false
+func parseIDMaps(value string) (maps []IDMap, ok bool, err error) {
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 {
+			return nil, false, nil
+		}
+		var ids [3]int
+		for i, part := range parts {
+			id, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, false, nil
+			}
+			ids[i] = id
+		}
+		if ids[0] < 0 || ids[1] < 0 || ids[2] <= 0 {
+			return nil, true, fmt.Errorf("invalid id mapping %s", item)
+		}
+		maps = append(maps, IDMap{ContainerID: ids[0], HostID: ids[1], Size: ids[2]})
+	}
+	return maps, true, nil
+}
+
+func lookupUserID(name string) string {
+	if u, err := user.Lookup(name); err == nil {
+		return u.Uid
+	}
+	return ""
+}
+
+func lookupGroupID(name string) string {
+	if g, err := user.LookupGroup(name); err == nil {
+		return g.Gid
+	}
+	return ""
+}
+
+// 从子 ID 文件中读取 name(或数字形式的 id)拥有的所有区间，按顺序从容器内的 0 开始依次映射
+func readSubIDs(file string, name string, id string) ([]IDMap, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("open %s error: %v", file, err)
+	}
+	defer f.Close()
+
+	var maps []IDMap
+	containerID := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 || (parts[0] != name && (id == "" || parts[0] != id)) {
+			continue
+		}
+		start, err1 := strconv.Atoi(parts[1])
+		count, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid line in %s: %s", file, line)
+		}
+		maps = append(maps, IDMap{ContainerID: containerID, HostID: start, Size: count})
+		containerID += count
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(maps) == 0 {
+		return nil, fmt.Errorf("no subordinate ids found for %s in %s", name, file)
+	}
+	return maps, nil
+}
+
+// 将容器内的 ID 转换为宿主机上的 ID，没有映射时 ok 返回 false
+func toHostID(maps []IDMap, id int) (int, bool) {
+	for _, m := range maps {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return m.HostID + id - m.ContainerID, true
+		}
+	}
+	return -1, false
+}
+
+// 将宿主机上的 ID 转换为容器内的 ID，即 toHostID 的逆映射，没有映射时 ok 返回 false
+func toContainerID(maps []IDMap, id int) (int, bool) {
+	for _, m := range maps {
+		if id >= m.HostID && id < m.HostID+m.Size {
+			return m.ContainerID + id - m.HostID, true
+		}
+	}
+	return -1, false
+}
+
+// 容器内看不到映射的 ID 时显示为 overflowuid/overflowgid
+const overflowID = 65534
+
+// 生成 GNU tar 的 --owner-map/--group-map 文件内容，每行把一个宿主机 ID 转换为容器内的 ID
+// 没有映射的 ID 在容器内显示为 overflowID，打包后也使用该 ID
+func idMapLines(ids []int, maps []IDMap) string {
+	sort.Ints(ids)
+	var b strings.Builder
+	for _, id := range ids {
+		containerID, ok := toContainerID(maps, id)
+		if !ok {
+			containerID = overflowID
+		}
+		if containerID != id {
+			fmt.Fprintf(&b, "+%d +%d\n", id, containerID)
+		}
+	}
+	return b.String()
+}
+
+// 启用 user namespace 的容器中，文件在宿主机上的属主是映射后的 ID
+// 打包时转换回容器内的 ID，使提交的镜像与容器内看到的属主一致，没有启用 user namespace 的容器也能正常使用
+// 返回需要追加到 tar 命令的参数和用于清理临时映射文件的函数
+func tarOwnerMapArgs(dir string, containerInfo *ContainerInfo) ([]string, func(), error) {
+	if len(containerInfo.UidMappings) == 0 {
+		return nil, func() {}, nil
+	}
+	uids, gids := map[int]bool{}, map[int]bool{}
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uids[int(stat.Uid)] = true
+			gids[int(stat.Gid)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "sixDocker-commit-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+	ownerMap, groupMap := path.Join(tmpDir, "owner-map"), path.Join(tmpDir, "group-map")
+	if err := os.WriteFile(ownerMap, []byte(idMapLines(mapKeys(uids), containerInfo.UidMappings)), 0644); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if err := os.WriteFile(groupMap, []byte(idMapLines(mapKeys(gids), containerInfo.GidMappings)), 0644); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return []string{"--numeric-owner", "--owner-map=" + ownerMap, "--group-map=" + groupMap}, cleanup, nil
+}
+
+func mapKeys(set map[int]bool) []int {
+	keys := make([]int, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// 容器内的 root 对应的宿主机 uid 和 gid，没有启用 user namespace 时为 0
+func remappedRoot(containerInfo *ContainerInfo) (int, int) {
+	uid, ok := toHostID(containerInfo.UidMappings, 0)
+	if !ok {
+		uid = 0
+	}
+	gid, ok := toHostID(containerInfo.GidMappings, 0)
+	if !ok {
+		gid = 0
+	}
+	return uid, gid
+}
+
+func toSysProcIDMap(maps []IDMap) []syscall.SysProcIDMap {
+	var sysMaps []syscall.SysProcIDMap
+	for _, m := range maps {
+		sysMaps = append(sysMaps, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return sysMaps
+}
+
+// 把 dir 下所有文件的属主从容器内的 ID 转换为宿主机上的 ID，使容器内看到的属主保持不变
+// 容器 ID 区间与宿主机 ID 区间不重叠时，已经转换过的文件(例如属主为 100000)不会被重复转换
+func shiftOwnership(dir string, uidMaps []IDMap, gidMaps []IDMap) error {
+	return filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		uid, uidOk := toHostID(uidMaps, int(stat.Uid))
+		gid, gidOk := toHostID(gidMaps, int(stat.Gid))
+		if !uidOk {
+			uid = int(stat.Uid)
+		}
+		if !gidOk {
+			gid = int(stat.Gid)
+		}
+		if uid == int(stat.Uid) && gid == int(stat.Gid) {
+			return nil
+		}
+		if err := os.Lchown(name, uid, gid); err != nil {
+			return err
+		}
+		// chown 会清除 setuid/setgid 位，需要恢复
+		if info.Mode()&os.ModeSymlink == 0 && info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			return os.Chmod(name, info.Mode())
+		}
+		return nil
+	})
+}
+
+// 容器使用的只读层目录
+func readOnlyLayerPath(containerInfo *ContainerInfo) string {
+	if len(containerInfo.UidMappings) == 0 {
+		return path.Join(READONLYLAYERDIR, containerInfo.Image)
+	}
+	uid, gid := remappedRoot(containerInfo)
+	return path.Join(READONLYLAYERDIR, fmt.Sprintf("%d.%d", uid, gid), containerInfo.Image)
+}
+
+// 启用 user namespace 的容器使用按照映射转换过属主的只读层副本，保存在 READONLYLAYERDIR/<uid>.<gid>/<镜像> 下
+// 原始的只读层仍然供没有启用 user namespace 的容器使用
+func createRemappedReadOnlyLayer(containerInfo *ContainerInfo) (string, error) {
+	uid, gid := remappedRoot(containerInfo)
+	targetDir := readOnlyLayerPath(containerInfo)
+	if _, err := os.Stat(targetDir); err == nil {
+		return targetDir, nil
+	}
+	baseDir, err := CreateReadOnlyLayer(containerInfo.Image)
+	if err != nil {
+		return "", err
+	}
+
+	// 先在临时目录中复制并转换属主，完成后再 rename，避免留下只转换了一半的只读层
+	tmpDir := targetDir + ".tmp"
+	os.RemoveAll(tmpDir)
+	if err := os.MkdirAll(path.Dir(targetDir), 0755); err != nil {
+		return "", err
+	}
+	log.Infof("Copying %s to %s for uid %d gid %d", baseDir, targetDir, uid, gid)
+	if output, err := exec.Command("cp", "-a", baseDir, tmpDir).CombinedOutput(); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", fmt.Errorf("copy %s error: %v, output: %s", baseDir, err, output)
+	}
+	if err := shiftOwnership(tmpDir, containerInfo.UidMappings, containerInfo.GidMappings); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", fmt.Errorf("shift ownership of %s error: %v", tmpDir, err)
+	}
+	if err := os.Rename(tmpDir, targetDir); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	return targetDir, nil
+}
+
+// 容器目录默认只有宿主机 root 可以访问，容器内的 root 映射为普通用户后无法进入挂载点
+// 允许其他用户穿过容器根目录，容器目录只允许容器 root 所在的组穿过
+func allowRemappedRootTraversal(containerInfo *ContainerInfo) error {
+	_, gid := remappedRoot(containerInfo)
+	if err := os.Chmod(containerDir(""), 0711); err != nil {
+		return err
+	}
+	dir := containerDir(containerInfo.Id)
+	if err := os.Chown(dir, 0, gid); err != nil {
+		return err
+	}
+	return os.Chmod(dir, 0710)
+}
@@ -0,0 +1,84 @@
+// exp/sixDocker/container/userns_test.go
+
+package container
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestParseUsernsRemap(t *testing.T) {
+	dir := t.TempDir()
+	oldUid, oldGid := subuidFile, subgidFile
+	subuidFile, subgidFile = path.Join(dir, "subuid"), path.Join(dir, "subgid")
+	defer func() {
+		subuidFile, subgidFile = oldUid, oldGid
+	}()
+	os.WriteFile(subuidFile, []byte("# comment\nalice:100000:65536\nbob:300000:1000\nalice:500000:10\n"), 0644)
+	os.WriteFile(subgidFile, []byte("alice:100000:65536\nstaff:400000:65536\n"), 0644)
+
+	uids, gids, err := ParseUsernsRemap("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantUids := []IDMap{{0, 100000, 65536}, {65536, 500000, 10}}
+	if !reflect.DeepEqual(uids, wantUids) {
+		t.Errorf("uid mappings = %v, want %v", uids, wantUids)
+	}
+	if !reflect.DeepEqual(gids, []IDMap{{0, 100000, 65536}}) {
+		t.Errorf("gid mappings = %v", gids)
+	}
+
+	_, gids, err = ParseUsernsRemap("alice:staff")
+	if err != nil || !reflect.DeepEqual(gids, []IDMap{{0, 400000, 65536}}) {
+		t.Errorf("gid mappings = %v, %v", gids, err)
+	}
+
+	uids, gids, err = ParseUsernsRemap("0:200000:1000,1000:300000:10")
+	want := []IDMap{{0, 200000, 1000}, {1000, 300000, 10}}
+	if err != nil || !reflect.DeepEqual(uids, want) || !reflect.DeepEqual(gids, want) {
+		t.Errorf("explicit mappings = %v %v, %v", uids, gids, err)
+	}
+
+	for _, remap := range []string{"nobody-here", "0:200000:0"} {
+		if _, _, err := ParseUsernsRemap(remap); err == nil {
+			t.Errorf("ParseUsernsRemap(%s) expected error", remap)
+		}
+	}
+}
+
+func TestToHostID(t *testing.T) {
+	maps := []IDMap{{0, 100000, 1000}, {1000, 500000, 10}}
+	cases := map[int]int{0: 100000, 999: 100999, 1000: 500000, 1009: 500009}
+	for id, want := range cases {
+		if got, ok := toHostID(maps, id); !ok || got != want {
+			t.Errorf("toHostID(%d) = %d, %v, want %d", id, got, ok, want)
+		}
+	}
+	if _, ok := toHostID(maps, 1010); ok {
+		t.Errorf("toHostID(1010) expected no mapping")
+	}
+}
+
+func TestToContainerID(t *testing.T) {
+	maps := []IDMap{{0, 100000, 1000}, {1000, 500000, 10}}
+	for id := range map[int]bool{0: true, 999: true, 1000: true, 1009: true} {
+		hostID, _ := toHostID(maps, id)
+		if got, ok := toContainerID(maps, hostID); !ok || got != id {
+			t.Errorf("toContainerID(%d) = %d, %v, want %d", hostID, got, ok, id)
+		}
+	}
+	for _, hostID := range []int{0, 99999, 101000, 500010} {
+		if _, ok := toContainerID(maps, hostID); ok {
+			t.Errorf("toContainerID(%d) expected no mapping", hostID)
+		}
+	}
+
+	got := idMapLines([]int{500003, 0, 100000, 100033}, maps)
+	want := "+0 +65534\n+100000 +0\n+100033 +33\n+500003 +1003\n"
+	if got != want {
+		t.Errorf("idMapLines = %q, want %q", got, want)
+	}
+}
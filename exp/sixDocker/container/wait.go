@@ -0,0 +1,57 @@
+// exp/sixDocker/container/wait.go
+
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// wait 命令支持的等待条件
+const (
+	WaitConditionNotRunning = "not-running"
+	WaitConditionRemoved    = "removed"
+)
+
+// 阻塞直到容器满足等待条件，返回容器最后一次退出的退出码
+func WaitContainer(containerName string, condition string) (int, error) {
+	if condition != WaitConditionNotRunning && condition != WaitConditionRemoved {
+		return -1, fmt.Errorf("invalid wait condition %s", condition)
+	}
+	containerInfo, err := GetContainerInfo(containerName)
+	if err != nil {
+		return -1, err
+	}
+	// 之后按照 ID 查询，等待期间容器被重命名也不受影响
+	containerId := containerInfo.Id
+	for {
+		if condition == WaitConditionNotRunning && !IsContainerRunning(containerInfo) {
+			return containerInfo.ExitCode, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+		latest, err := GetContainerInfo(containerId)
+		if err != nil {
+			if !checkContainerExists(containerId) {
+				// 容器已经被删除，返回删除前最后一次记录的退出码
+				return containerInfo.ExitCode, nil
+			}
+			// config.json 正在被改写，下一轮重新读取
+			continue
+		}
+		containerInfo = latest
+	}
+}
+
+// 判断容器是否处于运行中(包括 paused 和 restarting)
+// 状态停留在 running 但容器进程和等待它的进程都已经不存在时(例如宿主机重启)，视为已经停止
+func IsContainerRunning(containerInfo *ContainerInfo) bool {
+	switch containerInfo.Status {
+	case RUNNING, PAUSED, RESTARTING:
+	default:
+		return false
+	}
+	pid, _ := strconv.Atoi(containerInfo.Pid)
+	monitorPid, _ := strconv.Atoi(containerInfo.MonitorPid)
+	return isProcessRunning(pid, containerInfo.PidStartTime) || isProcessRunning(monitorPid, containerInfo.MonitorStartTime)
+}
@@ -0,0 +1,63 @@
+// exp/sixDocker/create.go
+
+package main
+
+import (
+	"fmt"
+	"sixDocker/container"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 创建容器但不启动，输出容器 ID
+func Create(spec *container.ContainerInfo) error {
+	containerInfo, err := createContainer(spec)
+	if err != nil {
+		return err
+	}
+	fmt.Println(containerInfo.Id)
+	return nil
+}
+
+// 在后台启动 created 状态的容器，或者重新启动已经停止的容器
+// 停止的容器会重新挂载原来的可写层，容器内的文件修改不会丢失
+func Start(containerName string) error {
+	containerId, err := container.ResolveContainerID(containerName)
+	if err != nil {
+		return err
+	}
+	// 持有启动锁直到容器进程启动完成，并发的 start 会在锁内看到容器已经在运行
+	unlock, err := container.LockContainerStart(containerId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// 宿主机重启等原因导致状态停留在 running 但进程已经不存在的容器，需要先回收残留的资源
+	stale := false
+	containerInfo, err := container.UpdateContainerInfo(containerId, func(latest *container.ContainerInfo) error {
+		if container.IsContainerRunning(latest) {
+			return fmt.Errorf("container %s is %s, cannot start it", containerName, latest.Status)
+		}
+		stale = latest.Status != container.CREATED && latest.Status != container.STOPPED && latest.Status != container.EXIT
+		if stale {
+			latest.Status = container.EXIT
+			latest.Pid = ""
+		}
+		// 手动启动的容器重新按照重启策略重启，重启次数从 0 开始计算
+		latest.ManuallyStopped = false
+		latest.RestartCount = 0
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if stale {
+		releaseContainerResources(containerInfo)
+	}
+	if err := startMonitor(containerInfo); err != nil {
+		return fmt.Errorf("start container %s error: %v", containerName, err)
+	}
+	log.Infof("Container %s started", containerName)
+	return nil
+}
@@ -0,0 +1,59 @@
+// exp/sixDocker/fileutil/fileutil_test.go
+
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "config.json")
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFileAtomic(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filename)
+		if err != nil || string(got) != content {
+			t.Errorf("read %s = %q, %v, want %q", filename, got, err, content)
+		}
+	}
+	// 不应该留下临时文件
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("expected only the target file, got %d files", len(files))
+	}
+}
+
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+	lockPath := filepath.Join(dir, "counter.lock")
+	counterPath := filepath.Join(dir, "counter")
+
+	// flock 锁在不同的打开文件之间互斥，同一进程内的多个 goroutine 也可以用来验证
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := Lock(lockPath)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer unlock()
+			content, _ := os.ReadFile(counterPath)
+			n, _ := strconv.Atoi(string(content))
+			WriteFileAtomic(counterPath, []byte(strconv.Itoa(n+1)), 0644)
+		}()
+	}
+	wg.Wait()
+	content, _ := os.ReadFile(counterPath)
+	if string(content) != "20" {
+		t.Errorf("counter = %s, want 20", content)
+	}
+}
@@ -0,0 +1,130 @@
+// exp/sixDocker/format/format.go
+
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/template"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// --format json 时每行输出一个 JSON 对象
+const JSONFormat = "json"
+
+// 列表命令(ps、images、network list)共用的输出选项
+type Options struct {
+	Format  string // Go template 或 json，为空时输出表格
+	Quiet   bool   // 只输出 ID
+	NoTrunc bool   // 表格中不截断过长的值
+}
+
+// 表格中的一列
+type Column struct {
+	Header string
+	Value  func(item interface{}) string
+	Width  int  // 超过该长度的值会被截断，0 表示不截断
+	Cut    bool // 截断时直接截取前 Width 个字符，不加省略号(用于 ID)
+}
+
+// 解析 Go template，模板中可以使用 json 函数把字段输出为 JSON
+func Parse(format string) (*template.Template, error) {
+	tmpl, err := template.New("format").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("parse format template error: %v", err)
+	}
+	return tmpl, nil
+}
+
+// 按照输出选项输出列表，id 用于 -q 模式
+func Write(w io.Writer, items []interface{}, columns []Column, id func(item interface{}) string, opts Options) error {
+	switch {
+	case opts.Quiet:
+		for _, item := range items {
+			fmt.Fprintln(w, id(item))
+		}
+		return nil
+	case opts.Format == JSONFormat:
+		encoder := json.NewEncoder(w)
+		for _, item := range items {
+			if err := encoder.Encode(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case opts.Format != "":
+		tmpl, err := Parse(opts.Format)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := tmpl.Execute(w, item); err != nil {
+				return fmt.Errorf("execute format template error: %v", err)
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	}
+
+	table := tablewriter.NewWriter(w)
+	header := make([]string, 0, len(columns))
+	for _, column := range columns {
+		header = append(header, column.Header)
+	}
+	table.SetHeader(header)
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	table.SetTablePadding("\t")
+	for _, item := range items {
+		row := make([]string, 0, len(columns))
+		for _, column := range columns {
+			value := column.Value(item)
+			if !opts.NoTrunc {
+				if column.Cut {
+					value = Cut(value, column.Width)
+				} else {
+					value = Truncate(value, column.Width)
+				}
+			}
+			row = append(row, value)
+		}
+		table.Append(row)
+	}
+	table.Render()
+	return nil
+}
+
+// 把字符串截断到 width 个字符，被截断时以 … 结尾
+func Truncate(value string, width int) string {
+	runes := []rune(value)
+	if width <= 0 || len(runes) <= width {
+		return value
+	}
+	if width == 1 {
+		return "…"
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// 截取字符串的前 width 个字符
+func Cut(value string, width int) string {
+	runes := []rune(value)
+	if width <= 0 || len(runes) <= width {
+		return value
+	}
+	return string(runes[:width])
+}
@@ -0,0 +1,63 @@
+// exp/sixDocker/format/format_test.go
+
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type testItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+var testColumns = []Column{
+	{Header: "ID", Width: 4, Value: func(item interface{}) string { return item.(*testItem).ID }},
+	{Header: "NAME", Value: func(item interface{}) string { return item.(*testItem).Name }},
+}
+
+func writeTestItems(t *testing.T, opts Options) string {
+	items := []interface{}{
+		&testItem{ID: "0123456789", Name: "a"},
+		&testItem{ID: "abcdef", Name: "b"},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, items, testColumns, func(item interface{}) string {
+		return item.(*testItem).ID
+	}, opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestWriteQuiet(t *testing.T) {
+	if out := writeTestItems(t, Options{Quiet: true}); out != "0123456789\nabcdef\n" {
+		t.Errorf("unexpected quiet output %q", out)
+	}
+}
+
+func TestWriteTemplate(t *testing.T) {
+	if out := writeTestItems(t, Options{Format: "{{.Name}}={{.ID}}"}); out != "a=0123456789\nb=abcdef\n" {
+		t.Errorf("unexpected template output %q", out)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	want := "{\"id\":\"0123456789\",\"name\":\"a\"}\n{\"id\":\"abcdef\",\"name\":\"b\"}\n"
+	if out := writeTestItems(t, Options{Format: JSONFormat}); out != want {
+		t.Errorf("unexpected json output %q", out)
+	}
+}
+
+func TestWriteTableTruncate(t *testing.T) {
+	out := writeTestItems(t, Options{})
+	if !strings.Contains(out, "012…") || strings.Contains(out, "0123456789") {
+		t.Errorf("id is not truncated: %q", out)
+	}
+	out = writeTestItems(t, Options{NoTrunc: true})
+	if !strings.Contains(out, "0123456789") {
+		t.Errorf("id is truncated with NoTrunc: %q", out)
+	}
+}
@@ -0,0 +1,113 @@
+// exp/sixDocker/inspect.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sixDocker/container"
+	"sixDocker/format"
+	"sixDocker/network"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// inspect 输出的容器信息: config.json 中的全部字段，加上网络端点、overlay 各层路径和数据卷
+type containerInspect struct {
+	*container.ContainerInfo
+	GraphDriver     *container.LayerPaths `json:"graphDriver"`
+	Mounts          []container.Mount     `json:"mounts"`
+	NetworkSettings *network.Endpoint     `json:"networkSettings"`
+}
+
+type networkInspect struct {
+	*network.Network
+	Subnet    string              `json:"subnet"`
+	Endpoints []*network.Endpoint `json:"endpoints"`
+}
+
+func inspectContainers(names []string, formatText string) error {
+	network.Init()
+	return inspectObjects(names, formatText, func(containerName string) (interface{}, error) {
+		containerInfo, err := container.GetContainerInfo(containerName)
+		if err != nil {
+			return nil, err
+		}
+		result := &containerInspect{
+			ContainerInfo: containerInfo,
+			GraphDriver:   container.GetLayerPaths(containerInfo),
+			Mounts:        container.GetMounts(containerInfo.Volume),
+		}
+		if containerInfo.Network != "" {
+			if result.NetworkSettings, err = network.GetEndpoint(containerInfo.Network, containerInfo); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	})
+}
+
+func inspectImages(names []string, formatText string) error {
+	return inspectObjects(names, formatText, func(imageName string) (interface{}, error) {
+		return container.GetImageInfo(imageName)
+	})
+}
+
+func inspectNetworks(names []string, formatText string) error {
+	if err := network.Init(); err != nil {
+		return err
+	}
+	return inspectObjects(names, formatText, func(nwName string) (interface{}, error) {
+		nw, endpoints, err := network.GetNetwork(nwName)
+		if err != nil {
+			return nil, err
+		}
+		return &networkInspect{
+			Network:   nw,
+			Subnet:    nw.IpRange.String(),
+			Endpoints: endpoints,
+		}, nil
+	})
+}
+
+// 依次获取每个对象并输出，format 为空时输出 JSON 数组，否则按照 Go template 逐个输出
+// 某个对象获取失败时继续处理其余对象，最后返回错误
+func inspectObjects(names []string, formatText string, get func(string) (interface{}, error)) error {
+	var tmpl *template.Template
+	if formatText != "" {
+		var err error
+		if tmpl, err = format.Parse(formatText); err != nil {
+			return err
+		}
+	}
+
+	var lastErr error
+	objects := []interface{}{}
+	for _, name := range names {
+		object, err := get(name)
+		if err != nil {
+			log.Errorf("Inspect %s error: %v", name, err)
+			lastErr = err
+			continue
+		}
+		objects = append(objects, object)
+	}
+
+	if tmpl == nil {
+		out, err := json.MarshalIndent(objects, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return lastErr
+	}
+	for _, object := range objects {
+		if err := tmpl.Execute(os.Stdout, object); err != nil {
+			return fmt.Errorf("execute format template error: %v", err)
+		}
+		fmt.Println()
+	}
+	return lastErr
+}
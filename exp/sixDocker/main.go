@@ -22,23 +22,40 @@ func main() {
 	// cli子命令定义
 	app.Commands = []cli.Command{
 		initCommand,
+		monitorCommand,
 		runCommand,
+		createCommand,
+		startCommand,
 		commitCommand,
 		listCommand,
 		logsCommand,
 		execCommand,
 		stopCpmmand,
+		killCommand,
+		pauseCommand,
+		unpauseCommand,
+		waitCommand,
+		renameCommand,
+		inspectCommand,
 		removeCommand,
 		ShowAllImagesCommand,
+		imageCommand,
 		networkCommand,
+		systemCommand,
 	}
 
+	app.Flags = globalFlags
+
 	// cli全局配置
 	app.Before = func(context *cli.Context) error {
 		// Log as JSON instead of the default ASCII formatter.
 		log.SetFormatter(&log.TextFormatter{})
-		log.SetOutput(os.Stdout)
-		return nil
+		// 日志输出到标准错误，标准输出只留给命令的结果，便于脚本解析
+		log.SetOutput(os.Stderr)
+		if err := setupRootless(context); err != nil {
+			return err
+		}
+		return setupRoot(context)
 	}
 
 	// cli运行 解析命令行参数 ./sixDocker run -ti -m 100m -- stress --vm-bytes 800m --vm-keep -m 1
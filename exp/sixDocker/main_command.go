@@ -7,12 +7,105 @@ import (
 	"os"
 	"sixDocker/cgroups/subsystems"
 	"sixDocker/container"
+	"sixDocker/format"
 	"sixDocker/network"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
+// run 和 create 共用的容器配置参数
+var containerFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "m",
+		Usage: "memory limit",
+	},
+	cli.StringFlag{
+		Name:  "cpushare",
+		Usage: "cpushare limit",
+	},
+	cli.StringFlag{
+		Name:  "cpuset",
+		Usage: "cpuset limit",
+	},
+	cli.StringFlag{
+		Name:  "cpus",
+		Usage: "number of cpus, e.g. 1.5",
+	},
+	cli.StringSliceFlag{
+		Name:  "v",
+		Usage: "volume",
+	},
+	cli.StringFlag{
+		Name:  "name",
+		Usage: "container name",
+	},
+	cli.StringSliceFlag{
+		Name:  "e",
+		Usage: "environment variables",
+	},
+	cli.StringFlag{
+		Name:  "network",
+		Usage: "container network",
+		Value: "bridge",
+	},
+	cli.StringSliceFlag{
+		Name:  "p",
+		Usage: "port mapping",
+	},
+	cli.StringFlag{
+		Name:  "image",
+		Usage: "container image",
+		Value: "busybox",
+	},
+	cli.BoolFlag{
+		Name:  "rm",
+		Usage: "automatically remove the container when it exits",
+	},
+	cli.BoolFlag{
+		Name:  "init",
+		Usage: "run an init inside the container that forwards signals and reaps processes",
+	},
+	cli.StringFlag{
+		Name:  "stop-signal",
+		Usage: "signal to stop the container",
+		Value: container.DefaultStopSignal,
+	},
+	cli.StringSliceFlag{
+		Name:  "label",
+		Usage: "set metadata on the container, key=value",
+	},
+	cli.StringSliceFlag{
+		Name:  "label-file",
+		Usage: "read in a line delimited file of labels",
+	},
+	cli.StringFlag{
+		Name:  "restart",
+		Usage: "restart policy: no | on-failure[:N] | always | unless-stopped",
+		Value: container.RestartNo,
+	},
+	cli.StringFlag{
+		Name:  "userns-remap",
+		Usage: "run the container in a user namespace: USER[:GROUP] from /etc/subuid and /etc/subgid, or CONTAINERID:HOSTID:SIZE[,...]",
+	},
+	cli.StringSliceFlag{
+		Name:  "cap-add",
+		Usage: "add Linux capabilities, e.g. NET_ADMIN or ALL",
+	},
+	cli.StringSliceFlag{
+		Name:  "cap-drop",
+		Usage: "drop Linux capabilities, e.g. CHOWN or ALL",
+	},
+	cli.StringSliceFlag{
+		Name:  "security-opt",
+		Usage: "security options: seccomp=PROFILE.json, seccomp=unconfined or no-new-privileges",
+	},
+	cli.BoolFlag{
+		Name:  "read-only",
+		Usage: "mount the container's root filesystem as read only, with tmpfs on /tmp and /run",
+	},
+}
+
 var runCommand = cli.Command{
 	Name: "run",
 	Usage: `Create a container with namespace and cgroup limit
@@ -20,91 +113,158 @@ var runCommand = cli.Command{
 	// cli选项定义 选项参数以 - 或者 -- 开头
 	// boolFlag: 不出现则为false 出现则为true
 	// stringFlag: 不出现则为默认值 出现则为其后跟的字符串值
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		cli.BoolFlag{
 			Name:  "ti",
 			Usage: "enable tty",
 		},
-		cli.StringFlag{
-			Name:  "m",
-			Usage: "memory limit",
-		},
-		cli.StringFlag{
-			Name:  "cpushare",
-			Usage: "cpushare limit",
-		},
-		cli.StringFlag{
-			Name:  "cpuset",
-			Usage: "cpuset limit",
-		},
-		cli.StringSliceFlag{
-			Name:  "v",
-			Usage: "volume",
-		},
 		cli.BoolFlag{
 			Name:  "d",
 			Usage: "run container in background",
 		},
-		cli.StringFlag{
-			Name:  "name",
-			Usage: "container name",
-		},
-		cli.StringSliceFlag{
-			Name:  "e",
-			Usage: "environment variables",
-		},
-		cli.StringFlag{
-			Name:  "network",
-			Usage: "container network",
-			Value: "bridge",
-		},
-		cli.StringSliceFlag{
-			Name:  "p",
-			Usage: "port mapping",
-		},
-		cli.StringFlag{
-			Name:  "image",
-			Usage: "container image",
-			Value: "busybox",
-		},
-	},
+	}, containerFlags...),
 	Action: func(context *cli.Context) error {
-		if len(context.Args()) < 1 {
-			return fmt.Errorf("missing container command")
-		}
-
-		// 获取未被flag解析的参数(命令和命令参数)
-		args := context.Args()
-		if len(args) == 0 {
-			return fmt.Errorf("missing container command")
-		}
-		cmdArray := []string(args)
-
 		tty := context.Bool("ti")
 		d := context.Bool("d")
 		if tty && d {
 			return fmt.Errorf("ti and d paramter can not both provided")
 		}
-		containerName := context.String("name")
+		spec, err := parseContainerSpec(context)
+		if err != nil {
+			return err
+		}
+		Run(spec, tty)
+		return nil
+	},
+}
+
+var createCommand = cli.Command{
+	Name: "create",
+	Usage: `Create a container without starting it
+			./sixDocker create -name si -- top
+			./sixDocker start si`,
+	Flags: containerFlags,
+	Action: func(context *cli.Context) error {
+		spec, err := parseContainerSpec(context)
+		if err != nil {
+			return err
+		}
+		return Create(spec)
+	},
+}
+
+var startCommand = cli.Command{
+	Name: "start",
+	Usage: `Start a created or stopped container in background
+			./sixDocker start [containerName]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get(0)
+		return Start(containerName)
+	},
+}
+
+// 容器要连接的网络
+// rootless 模式下无法创建 veth 和 iptables 规则，容器只有 loopback 网卡，默认不连接网络
+func containerNetwork(context *cli.Context) (string, error) {
+	if !container.Rootless {
+		return context.String("network"), nil
+	}
+	if context.IsSet("network") && context.String("network") != "" {
+		return "", fmt.Errorf("-network is not supported in rootless mode")
+	}
+	if len(context.StringSlice("p")) > 0 {
+		return "", fmt.Errorf("-p is not supported in rootless mode")
+	}
+	return "", nil
+}
+
+// 从命令行参数中解析容器配置，run 和 create 共用
+func parseContainerSpec(context *cli.Context) (*container.ContainerInfo, error) {
+	// 获取未被flag解析的参数(命令和命令参数)
+	args := context.Args()
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing container command")
+	}
+	cmdArray := []string(args)
+
+	restartPolicy, err := container.ParseRestartPolicy(context.String("restart"))
+	if err != nil {
+		return nil, err
+	}
+	// 自动删除的容器退出后就不存在了，无法再按照重启策略重启
+	if context.Bool("rm") && restartPolicy.Name != container.RestartNo {
+		return nil, fmt.Errorf("conflicting options: -restart and -rm")
+	}
+	stopSignal := context.String("stop-signal")
+	if _, err := container.ParseSignal(stopSignal); err != nil {
+		return nil, err
+	}
+	labels, err := container.ParseLabels(context.StringSlice("label"), context.StringSlice("label-file"))
+	if err != nil {
+		return nil, err
+	}
+	// rootless 模式下 sixDocker 本身已经运行在 user namespace 中
+	if container.Rootless && context.String("userns-remap") != "" {
+		return nil, fmt.Errorf("-userns-remap is not supported in rootless mode")
+	}
+	uidMappings, gidMappings, err := container.ParseUsernsRemap(context.String("userns-remap"))
+	if err != nil {
+		return nil, err
+	}
+	capabilities, err := container.ParseCapabilities(context.StringSlice("cap-add"), context.StringSlice("cap-drop"))
+	if err != nil {
+		return nil, err
+	}
+	security, err := container.ParseSecurityOpts(context.StringSlice("security-opt"))
+	if err != nil {
+		return nil, err
+	}
+	nwName, err := containerNetwork(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return &container.ContainerInfo{
+		Name:         context.String("name"),
+		CommandArray: cmdArray,
 		// 从cli上下文中获取资源限制参数
-		resConf := &subsystems.ResourceConfig{
+		ResourceConfig: &subsystems.ResourceConfig{
 			CpuShare:    context.String("cpushare"),
 			CpuSet:      context.String("cpuset"),
 			MemoryLimit: context.String("m"),
-		}
-		// 获取 环境变量
-		envSlice := context.StringSlice("e")
-		// 获取 挂载卷
-		volume := context.StringSlice("v")
+			CpuQuota:    context.String("cpus"),
+		},
+		// 环境变量
+		Env: context.StringSlice("e"),
+		// 挂载卷
+		Volume: context.StringSlice("v"),
 		// 容器网络
-		networkName := context.String("network")
+		Network: nwName,
 		// 端口映射
-		portMapping := context.StringSlice("p")
+		PortMapping: context.StringSlice("p"),
 		// 镜像名称
-		imageName := context.String("image")
-		Run(resConf, tty, volume, containerName, envSlice, networkName, portMapping, imageName, cmdArray)
-		return nil
-	},
+		Image: context.String("image"),
+		// 重启策略
+		RestartPolicy: restartPolicy,
+		// 停止信号
+		StopSignal: stopSignal,
+		// 是否由 init 进程托管用户命令
+		Init: context.Bool("init"),
+		// 退出后是否自动删除
+		AutoRemove: context.Bool("rm"),
+		// 容器的元数据
+		Labels: labels,
+		// user namespace 的 uid/gid 映射
+		UidMappings:     uidMappings,
+		GidMappings:     gidMappings,
+		Capabilities:    capabilities,
+		SeccompProfile:  security.Seccomp,
+		NoNewPrivileges: security.NoNewPrivileges,
+		ReadOnly:        context.Bool("read-only"),
+	}, nil
 }
 
 var initCommand = cli.Command{
@@ -117,6 +277,17 @@ var initCommand = cli.Command{
 	},
 }
 
+var monitorCommand = cli.Command{
+	Name:  "monitor",
+	Usage: "Monitor a detached container process and clean it up after exit. Do not call it outside",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return Monitor(context.Args().Get(0))
+	},
+}
+
 var commitCommand = cli.Command{
 	Name:  "commit",
 	Usage: "Commit a container into image",
@@ -138,12 +309,89 @@ var commitCommand = cli.Command{
 	},
 }
 
+// ps、images、network list 共用的输出参数
+var listFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "format",
+		Usage: "format output using a Go template, or json",
+	},
+	cli.BoolFlag{
+		Name:  "q",
+		Usage: "only display IDs",
+	},
+	cli.BoolFlag{
+		Name:  "no-trunc",
+		Usage: "don't truncate output",
+	},
+}
+
+func listOptions(context *cli.Context) format.Options {
+	return format.Options{
+		Format:  context.String("format"),
+		Quiet:   context.Bool("q"),
+		NoTrunc: context.Bool("no-trunc"),
+	}
+}
+
+var filterFlag = cli.StringSliceFlag{
+	Name:  "filter",
+	Usage: "filter containers based on conditions: status, name, label, network, ancestor",
+}
+
+// 命令行中指定的容器以及所有满足 --filter 条件的容器
+func targetContainers(context *cli.Context) ([]string, error) {
+	containerNames := []string(context.Args())
+	if len(context.StringSlice("filter")) > 0 {
+		filters, err := container.ParseFilters(context.StringSlice("filter"))
+		if err != nil {
+			return nil, err
+		}
+		containers, err := container.LoadAllContainers()
+		if err != nil {
+			return nil, err
+		}
+		for _, containerInfo := range containers {
+			if filters.Match(containerInfo) {
+				containerNames = append(containerNames, containerInfo.Name)
+			}
+		}
+	} else if len(containerNames) == 0 {
+		return nil, fmt.Errorf("missing container name")
+	}
+	return containerNames, nil
+}
+
+// 依次处理每个容器，某个容器失败时继续处理其余容器，最后返回错误
+func forEachContainer(containerNames []string, fn func(containerName string) error) error {
+	var lastErr error
+	for _, containerName := range containerNames {
+		if err := fn(containerName); err != nil {
+			log.Errorf("Container %s: %v", containerName, err)
+			lastErr = err
+			continue
+		}
+		fmt.Println(containerName)
+	}
+	return lastErr
+}
+
 var listCommand = cli.Command{
-	Name:  "ps",
-	Usage: "List all the containers",
+	Name: "ps",
+	Usage: `List containers
+			mydocker ps [-a] [-filter status=exited] [-format TEMPLATE]`,
+	Flags: append([]cli.Flag{
+		cli.BoolFlag{
+			Name:  "a",
+			Usage: "show all containers (default shows just running)",
+		},
+		filterFlag,
+	}, listFlags...),
 	Action: func(context *cli.Context) error {
-		container.ListContainers()
-		return nil
+		filters, err := container.ParseFilters(context.StringSlice("filter"))
+		if err != nil {
+			return err
+		}
+		return container.ListContainers(context.Bool("a"), filters, listOptions(context))
 	},
 }
 
@@ -190,36 +438,173 @@ var execCommand = cli.Command{
 var stopCpmmand = cli.Command{
 	Name: "stop",
 	Usage: `Stop a container
-			mydocker stop [containerName]`,
+			mydocker stop [-t seconds] [-filter label=k=v] [containerName...]`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Usage: "seconds to wait for stop before killing it",
+			Value: container.DefaultStopTimeout,
+		},
+		filterFlag,
+	},
+	Action: func(context *cli.Context) error {
+		containerNames, err := targetContainers(context)
+		if err != nil {
+			return err
+		}
+		return forEachContainer(containerNames, func(containerName string) error {
+			return container.StopContainer(containerName, context.Int("t"))
+		})
+	},
+}
+
+var renameCommand = cli.Command{
+	Name: "rename",
+	Usage: `Rename a container
+			mydocker rename [containerName] [newName]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("missing container name or new name")
+		}
+		return container.RenameContainer(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
+var waitCommand = cli.Command{
+	Name: "wait",
+	Usage: `Block until one or more containers stop, then print their exit codes
+			mydocker wait [-condition not-running|removed] [containerName...]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "condition",
+			Usage: "wait until the container reaches the condition: not-running or removed",
+			Value: container.WaitConditionNotRunning,
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
-			return fmt.Errorf("missing container containerName")
+			return fmt.Errorf("missing container name")
 		}
+		var lastErr error
+		for _, containerName := range context.Args() {
+			exitCode, err := container.WaitContainer(containerName, context.String("condition"))
+			if err != nil {
+				log.Errorf("Wait container %s error: %v", containerName, err)
+				lastErr = err
+				continue
+			}
+			fmt.Println(exitCode)
+		}
+		return lastErr
+	},
+}
 
-		containerName := context.Args().Get(0)
-		return container.StopContainer(containerName)
+var pauseCommand = cli.Command{
+	Name: "pause",
+	Usage: `Pause all processes within a container
+			mydocker pause [containerName]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return container.PauseContainer(context.Args().Get(0))
 	},
 }
 
-var removeCommand = cli.Command{
-	Name: "rm",
-	Usage: `Remove a container
-			mydocker rm [containerName]`,
+var unpauseCommand = cli.Command{
+	Name: "unpause",
+	Usage: `Unpause all processes within a container
+			mydocker unpause [containerName]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return container.UnpauseContainer(context.Args().Get(0))
+	},
+}
+
+var killCommand = cli.Command{
+	Name: "kill",
+	Usage: `Send a signal to a running container
+			mydocker kill [-s SIGNAL] [containerName]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "s",
+			Usage: "signal to send to the container",
+			Value: "SIGKILL",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("missing container name")
 		}
 
 		containerName := context.Args().Get(0)
-		return container.DeleteContainer(containerName, false)
+		return container.KillContainer(containerName, context.String("s"))
+	},
+}
+
+var removeCommand = cli.Command{
+	Name: "rm",
+	Usage: `Remove containers
+			mydocker rm [-filter label=k=v] [containerName...]`,
+	Flags: []cli.Flag{
+		filterFlag,
+	},
+	Action: func(context *cli.Context) error {
+		containerNames, err := targetContainers(context)
+		if err != nil {
+			return err
+		}
+		return forEachContainer(containerNames, removeContainer)
 	},
 }
 
 var ShowAllImagesCommand = cli.Command{
 	Name:  "images",
 	Usage: "List all the images",
+	Flags: listFlags,
 	Action: func(context *cli.Context) error {
-		return container.ShowAllImages()
+		return container.ShowAllImages(listOptions(context))
+	},
+}
+
+var inspectFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "format",
+		Usage: "format the output using the given Go template",
+	},
+}
+
+var inspectCommand = cli.Command{
+	Name: "inspect",
+	Usage: `Display detailed information on one or more containers
+			mydocker inspect [-format TEMPLATE] [containerName...]`,
+	Flags: inspectFlags,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return inspectContainers(context.Args(), context.String("format"))
+	},
+}
+
+var imageCommand = cli.Command{
+	Name:  "image",
+	Usage: "image commands",
+	Subcommands: []cli.Command{
+		{
+			Name: "inspect",
+			Usage: `Display detailed information on one or more images
+					./sixDocker image inspect [-format TEMPLATE] [imageName...]`,
+			Flags: inspectFlags,
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing image name")
+				}
+				return inspectImages(context.Args(), context.String("format"))
+			},
+		},
 	},
 }
 
@@ -250,6 +635,9 @@ var networkCommand = cli.Command{
 				if err := network.Init(); err != nil {
 					return err
 				}
+				if container.Rootless {
+					return fmt.Errorf("network create is not supported in rootless mode")
+				}
 				nwName := context.Args().Get(0)
 				driver := context.String("driver")
 				subnet := context.String("subnet")
@@ -260,12 +648,24 @@ var networkCommand = cli.Command{
 			Name: "list",
 			Usage: `List all container networks
 					./sixDocker network list`,
+			Flags: listFlags,
 			Action: func(context *cli.Context) error {
 				if err := network.Init(); err != nil {
 					return err
 				}
-				network.ListNetwork()
-				return nil
+				return network.ListNetwork(listOptions(context))
+			},
+		},
+		{
+			Name: "inspect",
+			Usage: `Display detailed information on one or more networks
+					./sixDocker network inspect [-format TEMPLATE] [networkName...]`,
+			Flags: inspectFlags,
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing network name")
+				}
+				return inspectNetworks(context.Args(), context.String("format"))
 			},
 		},
 		{
@@ -285,3 +685,18 @@ var networkCommand = cli.Command{
 		},
 	},
 }
+
+var systemCommand = cli.Command{
+	Name:  "system",
+	Usage: "manage sixDocker state",
+	Subcommands: []cli.Command{
+		{
+			Name: "reconcile",
+			Usage: `Mark dead containers as exited and release leftover mounts, cgroups, IPs and port mappings
+					./sixDocker system reconcile`,
+			Action: func(context *cli.Context) error {
+				return Reconcile()
+			},
+		},
+	},
+}
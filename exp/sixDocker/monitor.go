@@ -0,0 +1,47 @@
+// exp/sixDocker/monitor.go
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sixDocker/container"
+	"strconv"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Monitor 是 -d 模式下容器进程的父进程(re-exec /proc/self/exe monitor)
+// 它启动容器并通过 3 号文件描述符向 run 进程报告结果，然后一直等待容器进程退出，
+// 记录退出码和退出时间，并执行与 -ti 模式相同的资源回收
+func Monitor(containerId string) error {
+	// run 进程通过 ExtraFiles 传入的管道写端
+	readyPipe := os.NewFile(uintptr(3), "ready")
+	// 避免管道写端泄露给 mount、iptables 等子进程，导致 run 进程无法读到 EOF
+	syscall.CloseOnExec(3)
+
+	containerInfo, err := container.GetContainerInfo(containerId)
+	if err != nil {
+		fmt.Fprintf(readyPipe, "get container %s info error: %v", containerId, err)
+		readyPipe.Close()
+		return err
+	}
+	containerInfo.MonitorPid = strconv.Itoa(os.Getpid())
+
+	parent, err := startContainer(containerInfo, false)
+	if err != nil {
+		markContainerFailed(containerInfo, err)
+		fmt.Fprintf(readyPipe, "start container %s error: %v", containerId, err)
+		readyPipe.Close()
+		autoRemoveContainer(containerInfo)
+		return err
+	}
+	fmt.Fprintf(readyPipe, "%d", parent.Process.Pid)
+	readyPipe.Close()
+
+	log.Infof("Monitor %d is waiting for container %s", os.Getpid(), containerId)
+	superviseContainer(parent, containerInfo, false)
+	autoRemoveContainer(containerInfo)
+	return nil
+}
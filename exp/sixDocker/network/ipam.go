@@ -3,12 +3,15 @@
 package network
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"log"
 	"net"
 	"os"
 	"path"
 	"strings"
+
+	"sixDocker/fileutil"
 )
 
 const ipamDefaultAllocatorPath = "/var/run/sixDocker/network/ipam/subnet.json"
@@ -50,34 +53,31 @@ func (ipam *IPAM) load() error {
 }
 
 func (ipam *IPAM) dump() error {
-	ipamConfigFileDir, _ := path.Split(ipam.SubnetAllocatorPath)
-	if _, err := os.Stat(ipamConfigFileDir); err != nil {
-		if os.IsNotExist(err) {
-			os.MkdirAll(ipamConfigFileDir, 0755)
-		} else {
-			return err
-		}
-	}
-
-	subnetConfigFile, err := os.OpenFile(ipam.SubnetAllocatorPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer subnetConfigFile.Close()
-
 	ipamConfigJson, err := json.Marshal(ipam.Subnets)
 	if err != nil {
 		return err
 	}
 
-	_, err = subnetConfigFile.Write(ipamConfigJson)
-	if err != nil {
-		return err
+	// 先写临时文件再 rename，避免进程崩溃时留下被截断的 subnet.json
+	return fileutil.WriteFileAtomic(ipam.SubnetAllocatorPath, ipamConfigJson, 0644)
+}
+
+// 对分配位图加跨进程排它锁，load-modify-dump 必须在锁内完成，否则并发 run 可能分到同一个 IP
+func (ipam *IPAM) lock() (func(), error) {
+	ipamConfigFileDir, _ := path.Split(ipam.SubnetAllocatorPath)
+	if err := os.MkdirAll(ipamConfigFileDir, 0755); err != nil {
+		return nil, err
 	}
-	return nil
+	return fileutil.Lock(path.Join(ipamConfigFileDir, ".subnet.lock"))
 }
 
 func (ipam *IPAM) Allocate(subnet *net.IPNet) (ip net.IP, err error) {
+	unlock, err := ipam.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	ipam.Subnets = make(map[string]string)
 
 	// 加载已有的分配信息
@@ -128,6 +128,12 @@ func (ipam *IPAM) Allocate(subnet *net.IPNet) (ip net.IP, err error) {
 }
 
 func (ipam *IPAM) Release(subnet *net.IPNet, ip *net.IP) error {
+	unlock, err := ipam.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// 加载已有的分配信息
 	ipam.Subnets = make(map[string]string)
 	if err := ipam.load(); err != nil {
@@ -155,3 +161,38 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ip *net.IP) error {
 	}
 	return nil
 }
+
+// 重建子网的分配位图，只保留 used 中的 IP，其他 IP 全部标记为未分配
+// 用于回收宿主机重启或进程崩溃后残留在位图中的 IP
+func (ipam *IPAM) Retain(subnet *net.IPNet, used []net.IP) error {
+	unlock, err := ipam.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ipam.Subnets = make(map[string]string)
+	if err := ipam.load(); err != nil {
+		return err
+	}
+
+	_, subnet, _ = net.ParseCIDR(subnet.String())
+	one, size := subnet.Mask.Size()
+	ipalloc := []byte(strings.Repeat("0", 1<<uint8(size-one)))
+	base := binary.BigEndian.Uint32(subnet.IP.To4())
+	for _, ip := range used {
+		ip4 := ip.To4()
+		if ip4 == nil || !subnet.Contains(ip4) {
+			continue
+		}
+		// 跳过了 *.*.*.0 地址，因此偏移要减 1
+		c := int(binary.BigEndian.Uint32(ip4)-base) - 1
+		if c >= 0 && c < len(ipalloc) {
+			ipalloc[c] = '1'
+		}
+	}
+	ipam.Subnets[subnet.String()] = string(ipalloc)
+
+	// 保存分配信息到磁盘
+	return ipam.dump()
+}
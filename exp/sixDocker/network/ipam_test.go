@@ -0,0 +1,60 @@
+// exp/sixDocker/network/ipam_test.go
+
+package network
+
+import (
+	"net"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestIPAMRetain(t *testing.T) {
+	ipam := &IPAM{SubnetAllocatorPath: path.Join(t.TempDir(), "subnet.json")}
+	_, subnet, _ := net.ParseCIDR("192.168.10.0/28")
+
+	// 先分配 4 个 IP: .1 为网关，.2 .3 .4 为端点
+	for i := 1; i <= 4; i++ {
+		ip, err := ipam.Allocate(subnet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := net.IPv4(192, 168, 10, byte(i)).To4(); !ip.Equal(want) {
+			t.Fatalf("Allocate = %s, want %s", ip, want)
+		}
+	}
+
+	// 只保留网关、.3 和 .15，其他网段的 IP 和 nil 忽略
+	gateway := net.ParseIP("192.168.10.1")
+	used := []net.IP{gateway, net.ParseIP("192.168.10.3"), net.ParseIP("192.168.10.15"), net.ParseIP("10.0.0.3"), nil}
+	// 传入网关所在的 IPNet，与网络配置中保存的 IpRange 一致
+	if err := ipam.Retain(&net.IPNet{IP: gateway, Mask: subnet.Mask}, used); err != nil {
+		t.Fatal(err)
+	}
+	ipam.Subnets = nil
+	if err := ipam.load(); err != nil {
+		t.Fatal(err)
+	}
+	want := "101" + strings.Repeat("0", 11) + "10"
+	if got := ipam.Subnets[subnet.String()]; got != want {
+		t.Errorf("bitmap = %s, want %s", got, want)
+	}
+
+	// 释放的 .2 会被重新分配，网关不会
+	ip, err := ipam.Allocate(subnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.ParseIP("192.168.10.2")) {
+		t.Errorf("Allocate after Retain = %s, want 192.168.10.2", ip)
+	}
+}
+
+func TestSubnetKey(t *testing.T) {
+	// 同一子网的两个网络共用一个分配位图
+	a := &net.IPNet{IP: net.ParseIP("172.18.0.1").To4(), Mask: net.CIDRMask(24, 32)}
+	b := &net.IPNet{IP: net.ParseIP("172.18.0.200").To4(), Mask: net.CIDRMask(24, 32)}
+	if subnetKey(a) != "172.18.0.0/24" || subnetKey(a) != subnetKey(b) {
+		t.Errorf("subnetKey = %s, %s", subnetKey(a), subnetKey(b))
+	}
+}
@@ -11,8 +11,9 @@ import (
 	"path"
 	"runtime"
 	"sixDocker/container"
+	"sixDocker/fileutil"
+	"sixDocker/format"
 	"strings"
-	"text/tabwriter"
 
 	"github.com/vishvananda/netns"
 
@@ -23,6 +24,7 @@ import (
 
 var (
 	defaultNetworkPath = "/var/run/sixDocker/network"
+	endpointPath       = path.Join(defaultNetworkPath, "endpoint")
 	drivers            = map[string]NetworkDriver{}
 	networks           = map[string]*Network{}
 )
@@ -51,35 +53,14 @@ type NetworkDriver interface {
 }
 
 func (nw *Network) dump(dumpDir string) error {
-	// 创建 Network 存储目录
-	if _, err := os.Stat(dumpDir); err != nil {
-		if os.IsNotExist(err) {
-			os.MkdirAll(dumpDir, 0644)
-		} else {
-			return err
-		}
-	}
-
-	// 创建 Network 存储文件(文件存在就清空内容)
-	nwPath := path.Join(dumpDir, nw.Name)
-	nwFile, err := os.OpenFile(nwPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		return err
-	}
-	defer nwFile.Close()
-
 	// 序列化 Network 对象到 JSON
 	nwJson, err := json.Marshal(nw)
 	if err != nil {
 		return err
 	}
 
-	// JSON 写入文件
-	_, err = nwFile.Write(nwJson)
-	if err != nil {
-		return err
-	}
-	return nil
+	// 先写临时文件再 rename 覆盖，保证网络文件不会只写了一半
+	return fileutil.WriteFileAtomic(path.Join(dumpDir, nw.Name), nwJson, 0644)
 }
 
 func (nw *Network) remove(dumpDir string) error {
@@ -117,6 +98,54 @@ func (nw *Network) load(dumpPath string) error {
 	return nil
 }
 
+// Endpoint 存储在网络目录下的 endpoint 子目录中，容器退出时根据它回收 veth、端口映射和 IP
+func (ep *Endpoint) dump(dumpDir string) error {
+	if err := os.MkdirAll(dumpDir, 0644); err != nil {
+		return err
+	}
+	epJson, err := json.Marshal(ep)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomic(path.Join(dumpDir, ep.ID), epJson, 0644)
+}
+
+func (ep *Endpoint) remove(dumpDir string) error {
+	if err := os.Remove(path.Join(dumpDir, ep.ID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (ep *Endpoint) load(dumpDir string) error {
+	epJson, err := os.ReadFile(path.Join(dumpDir, ep.ID))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(epJson, ep)
+}
+
+// 对网络和端点的增删加跨进程排它锁，IPAM 的锁总是在它之后获取
+// flock 对同一进程内的不同打开文件同样互斥，持锁期间不能再次调用加锁的函数
+func lockNetwork() (func(), error) {
+	if err := os.MkdirAll(defaultNetworkPath, 0755); err != nil {
+		return nil, err
+	}
+	return fileutil.Lock(path.Join(defaultNetworkPath, ".network.lock"))
+}
+
+// 锁文件和写入中的临时文件都以 . 开头，加载状态时跳过
+func isHiddenFile(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// 把网络、端点和 IPAM 的状态目录设置到 root 下
+func SetRoot(root string) {
+	defaultNetworkPath = path.Join(root, "network")
+	endpointPath = path.Join(defaultNetworkPath, "endpoint")
+	ipAllocator.SubnetAllocatorPath = path.Join(defaultNetworkPath, "ipam", "subnet.json")
+}
+
 func Init() error {
 	// 网络驱动注册
 	var nw = BridgeNetworkDriver{}
@@ -138,8 +167,8 @@ func Init() error {
 	}
 
 	for _, file := range files {
-		// 只要文件，跳过所有的子目录（如 ipam 目录）
-		if file.IsDir() {
+		// 只要文件，跳过所有的子目录（如 ipam 目录）以及锁文件和临时文件
+		if file.IsDir() || isHiddenFile(file.Name()) {
 			continue
 		}
 
@@ -168,8 +197,22 @@ func Init() error {
 
 func CreateNetwork(driver, subnet, name string) error {
 	log.Infof("Create network %s with driver %s and subnet %s", name, driver, subnet)
+	unlock, err := lockNetwork()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// 其他进程可能已经创建了同名网络，以磁盘上的状态为准
+	if _, err := os.Stat(path.Join(defaultNetworkPath, name)); err == nil {
+		return fmt.Errorf("network %s already exists", name)
+	}
+
 	// 分配网关 IP
 	_, cidr, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return err
+	}
 	ip, err := ipAllocator.Allocate(cidr)
 	if err != nil {
 		return err
@@ -187,23 +230,30 @@ func CreateNetwork(driver, subnet, name string) error {
 	return nw.dump(defaultNetworkPath)
 }
 
-func ListNetwork() {
-	// 初始化 tabwriter
-	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	fmt.Fprint(w, "NAME\tIP RANGE\tDRIVER\n")
-
-	// 遍历所有网络并打印
+func ListNetwork(opts format.Options) error {
+	items := make([]interface{}, 0, len(networks))
 	for _, nw := range networks {
-		fmt.Fprintf(w, "%s\t%s\t%s\n", nw.Name, nw.IpRange.String(), nw.Driver)
+		items = append(items, nw)
 	}
+	return format.Write(os.Stdout, items, networkColumns, func(item interface{}) string {
+		return item.(*Network).Name
+	}, opts)
+}
 
-	// 刷新输出
-	if err := w.Flush(); err != nil {
-		log.Errorf("List network error: %v", err)
-	}
+// network list 表格中的列
+var networkColumns = []format.Column{
+	{Header: "NAME", Value: func(item interface{}) string { return item.(*Network).Name }},
+	{Header: "IP RANGE", Value: func(item interface{}) string { return item.(*Network).IpRange.String() }},
+	{Header: "DRIVER", Value: func(item interface{}) string { return item.(*Network).Driver }},
 }
 
 func DeleteNetwork(nwName string) error {
+	unlock, err := lockNetwork()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// 获取网络对象
 	nw, ok := networks[nwName]
 	if !ok {
@@ -365,25 +415,103 @@ func deletePortMapping(ep *Endpoint) error {
 	return nil
 }
 
-func Connect(nwName string, cinfo *container.ContainerInfo) error {
+// 获取容器在网络中的端点，容器没有连接该网络时返回 nil
+func GetEndpoint(nwName string, cinfo *container.ContainerInfo) (*Endpoint, error) {
+	ep := &Endpoint{
+		ID: fmt.Sprintf("%s-%s", cinfo.Id, nwName),
+	}
+	if err := ep.load(endpointPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ep, nil
+}
+
+// 获取网络以及连接在该网络上的所有端点
+func GetNetwork(nwName string) (*Network, []*Endpoint, error) {
+	nw, ok := networks[nwName]
+	if !ok {
+		return nil, nil, fmt.Errorf("no such network %s", nwName)
+	}
+	files, err := os.ReadDir(endpointPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	endpoints := []*Endpoint{}
+	for _, file := range files {
+		if isHiddenFile(file.Name()) {
+			continue
+		}
+		ep := &Endpoint{ID: file.Name()}
+		if err := ep.load(endpointPath); err != nil {
+			log.Errorf("Error loading endpoint %s: %v", file.Name(), err)
+			continue
+		}
+		if ep.Network != nil && ep.Network.Name == nwName {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	return nw, endpoints, nil
+}
+
+// 为容器分配 IP 并保存端点信息，此时还没有创建 veth 设备
+// create 命令调用，start 时由 Connect 完成剩余的连接工作
+func CreateEndpoint(nwName string, cinfo *container.ContainerInfo) (*Endpoint, error) {
 	// 获取网络对象
 	nw, ok := networks[nwName]
 	if !ok {
-		return fmt.Errorf("no such network %s", nwName)
+		return nil, fmt.Errorf("no such network %s", nwName)
 	}
 
+	unlock, err := lockNetwork()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	// 分配 IP 地址
 	ip, err := ipAllocator.Allocate(nw.IpRange)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// 创建端点对象
 	ep := &Endpoint{
-		ID:         fmt.Sprintf("%s-%s", cinfo.Id, nw.Name),
-		IPAddress:  ip,
-		MacAddress: nil,
-		Network:    nw,
+		ID:          fmt.Sprintf("%s-%s", cinfo.Id, nw.Name),
+		IPAddress:   ip,
+		MacAddress:  nil,
+		Network:     nw,
+		PortMapping: cinfo.PortMapping,
+	}
+
+	// 先保存端点信息，后续步骤失败时也能通过 Disconnect 回收
+	if err := ep.dump(endpointPath); err != nil {
+		ipAllocator.Release(nw.IpRange, &ip)
+		return nil, err
+	}
+	return ep, nil
+}
+
+func Connect(nwName string, cinfo *container.ContainerInfo) error {
+	// 获取网络对象
+	nw, ok := networks[nwName]
+	if !ok {
+		return fmt.Errorf("no such network %s", nwName)
+	}
+
+	// 复用 create 时分配的端点，没有则分配新的 IP
+	ep := &Endpoint{
+		ID: fmt.Sprintf("%s-%s", cinfo.Id, nw.Name),
+	}
+	if err := ep.load(endpointPath); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if ep, err = CreateEndpoint(nwName, cinfo); err != nil {
+			return err
+		}
 	}
 
 	// 连接网络和端点
@@ -391,6 +519,11 @@ func Connect(nwName string, cinfo *container.ContainerInfo) error {
 		return err
 	}
 
+	// 保存 veth 设备信息
+	if err := ep.dump(endpointPath); err != nil {
+		return err
+	}
+
 	// 配置容器内的网络接口和路由
 	if err := configEndpointIpAddressAndRoute(ep, cinfo); err != nil {
 		return err
@@ -399,6 +532,123 @@ func Connect(nwName string, cinfo *container.ContainerInfo) error {
 	return configPortMapping(ep, cinfo)
 }
 
+// 断开容器与网络的连接: 删除端口映射、veth 设备，并释放容器 IP
 func Disconnect(nwName string, cinfo *container.ContainerInfo) error {
-	return nil
+	// 监控进程和 rm 可能同时回收同一个端点，在锁内重新加载端点，避免重复释放 IP
+	unlock, err := lockNetwork()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ep := &Endpoint{
+		ID: fmt.Sprintf("%s-%s", cinfo.Id, nwName),
+	}
+	if err := ep.load(endpointPath); err != nil {
+		// 没有端点信息说明容器没有连接过该网络
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	nw, ok := networks[nwName]
+	if !ok {
+		return fmt.Errorf("no such network %s", nwName)
+	}
+	return releaseEndpoint(nw, ep)
+}
+
+// 删除端点的端口映射和 veth 设备，释放 IP 并删除端点文件，调用方需要持有网络锁
+func releaseEndpoint(nw *Network, ep *Endpoint) error {
+	if err := deletePortMapping(ep); err != nil {
+		log.Errorf("delete port mapping error: %v", err)
+	}
+
+	// 只分配了 IP 还没有启动过的容器没有 veth 设备
+	if ep.Device.Name != "" {
+		if err := drivers[nw.Driver].Disconnect(nw, ep); err != nil {
+			log.Errorf("disconnect endpoint %s error: %v", ep.ID, err)
+		}
+	}
+
+	// Release 会修改传入的 IP，因此放在最后
+	if err := ipAllocator.Release(nw.IpRange, &ep.IPAddress); err != nil {
+		return err
+	}
+	return ep.remove(endpointPath)
+}
+
+// 回收残留的网络状态: 删除容器已经不存在的端点(端口映射、veth 设备和 IP)，
+// 再根据网关和剩余的端点重建各个网络的 IP 分配位图，释放没有被任何端点使用的 IP
+// containerExists 用来判断端点所属的容器是否还存在，返回被回收的端点
+func Reconcile(containerExists func(containerId string) bool) ([]string, error) {
+	unlock, err := lockNetwork()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	files, err := os.ReadDir(endpointPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var released []string
+	// 按子网统计仍在使用的 IP，网关 IP 始终保留
+	// 多个网络可以使用同一个子网，它们共用一个分配位图，因此不能按网络分别重建
+	inUse := map[string][]net.IP{}
+	subnets := map[string]*net.IPNet{}
+	for _, nw := range networks {
+		key := subnetKey(nw.IpRange)
+		subnets[key] = nw.IpRange
+		inUse[key] = append(inUse[key], nw.IpRange.IP)
+	}
+	for _, file := range files {
+		if isHiddenFile(file.Name()) {
+			continue
+		}
+		ep := &Endpoint{ID: file.Name()}
+		if err := ep.load(endpointPath); err != nil {
+			log.Errorf("Error loading endpoint %s: %v", file.Name(), err)
+			continue
+		}
+		if ep.Network == nil {
+			continue
+		}
+		nw, ok := networks[ep.Network.Name]
+		if !ok {
+			// 网络已经被删除，IP 也随着网络一起失效，只删除端点文件
+			log.Infof("Removing endpoint %s of removed network %s", ep.ID, ep.Network.Name)
+			deletePortMapping(ep)
+			ep.remove(endpointPath)
+			released = append(released, ep.ID)
+			continue
+		}
+		// 端点 ID 为 <容器 ID>-<网络名称>
+		containerId := strings.TrimSuffix(ep.ID, "-"+nw.Name)
+		if containerExists(containerId) {
+			key := subnetKey(nw.IpRange)
+			inUse[key] = append(inUse[key], ep.IPAddress)
+			continue
+		}
+		log.Infof("Releasing endpoint %s of removed container %s", ep.ID, containerId)
+		if err := releaseEndpoint(nw, ep); err != nil {
+			log.Errorf("Release endpoint %s error: %v", ep.ID, err)
+			continue
+		}
+		released = append(released, ep.ID)
+	}
+
+	for key, subnet := range subnets {
+		if err := ipAllocator.Retain(subnet, inUse[key]); err != nil {
+			log.Errorf("Rebuild ip allocation of subnet %s error: %v", key, err)
+		}
+	}
+	return released, nil
+}
+
+// 子网在分配位图中的 key，与 IPAM 一样使用去掉主机位之后的网段
+func subnetKey(ipRange *net.IPNet) string {
+	_, subnet, _ := net.ParseCIDR(ipRange.String())
+	return subnet.String()
 }
@@ -13,8 +13,83 @@ package nsenter
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <grp.h>
+#include <linux/capability.h>
+#include <linux/filter.h>
+#include <linux/seccomp.h>
+#include <sys/prctl.h>
+#include <sys/stat.h>
+#include <sys/syscall.h>
 #include <unistd.h>
 
+// 安装 exec 传入的 seccomp 过滤器，hex 为十六进制编码的 BPF 程序，每条指令 8 字节
+static int install_seccomp(const char *hex) {
+    size_t len = strlen(hex);
+    if (len == 0 || len % 16 != 0) {
+        errno = EINVAL;
+        return -1;
+    }
+    unsigned char *buf = malloc(len / 2);
+    if (!buf) {
+        return -1;
+    }
+    for (size_t i = 0; i < len / 2; i++) {
+        unsigned int byte;
+        if (sscanf(hex + 2 * i, "%2x", &byte) != 1) {
+            free(buf);
+            errno = EINVAL;
+            return -1;
+        }
+        buf[i] = (unsigned char)byte;
+    }
+    // 指令按照小端编码: code(2 字节)、jt、jf、k(4 字节)
+    size_t count = len / 16;
+    struct sock_filter *filter = calloc(count, sizeof(struct sock_filter));
+    if (!filter) {
+        free(buf);
+        return -1;
+    }
+    for (size_t i = 0; i < count; i++) {
+        unsigned char *p = buf + 8 * i;
+        filter[i].code = p[0] | (p[1] << 8);
+        filter[i].jt = p[2];
+        filter[i].jf = p[3];
+        filter[i].k = p[4] | (p[5] << 8) | (p[6] << 16) | ((__u32)p[7] << 24);
+    }
+    struct sock_fprog prog = { (unsigned short)count, filter };
+    int ret = prctl(PR_SET_SECCOMP, SECCOMP_MODE_FILTER, &prog, 0, 0);
+    free(filter);
+    free(buf);
+    return ret;
+}
+
+// 与容器的 init 进程一样，把 bounding、effective、permitted 集合限制为 mask，清空 inheritable 和 ambient 集合
+static int drop_capabilities(unsigned long long mask) {
+    int last = 0;
+    while (prctl(PR_CAPBSET_READ, last + 1, 0, 0, 0) >= 0) {
+        last++;
+    }
+    if (last < 63) {
+        mask &= (1ULL << (last + 1)) - 1;
+    }
+    for (int cap = 0; cap <= last; cap++) {
+        if (!(mask & (1ULL << cap)) && prctl(PR_CAPBSET_DROP, cap, 0, 0, 0) != 0) {
+            return -1;
+        }
+    }
+    // 老内核不支持 ambient 集合
+    if (prctl(PR_CAP_AMBIENT, PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0) != 0 && errno != EINVAL) {
+        return -1;
+    }
+    struct __user_cap_header_struct header = { _LINUX_CAPABILITY_VERSION_3, 0 };
+    struct __user_cap_data_struct data[2];
+    for (int i = 0; i < 2; i++) {
+        data[i].effective = data[i].permitted = (__u32)(mask >> (32 * i));
+        data[i].inheritable = 0;
+    }
+    return syscall(SYS_capset, &header, data);
+}
+
 __attribute__((constructor)) void enter_namespace() {
     char *sixDocker_pid = getenv("sixDocker_pid");
     if (!sixDocker_pid) {
@@ -27,9 +102,20 @@ __attribute__((constructor)) void enter_namespace() {
     }
 
     char nspath[1024];
-    char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
+    // user namespace 必须最先进入，之后才有权限进入归属于它的其他 namespace
+    char *namespaces[] = { "user", "ipc", "uts", "net", "pid", "mnt" };
+
+    // 容器没有使用 user namespace 时与当前进程在同一个 user namespace 中，不需要进入
+    struct stat self_userns, target_userns;
+    snprintf(nspath, sizeof(nspath), "/proc/%s/ns/user", sixDocker_pid);
+    int enter_userns = stat("/proc/self/ns/user", &self_userns) == 0 &&
+                       stat(nspath, &target_userns) == 0 &&
+                       self_userns.st_ino != target_userns.st_ino;
 
-    for (int i = 0; i < 5; i++) {
+    for (int i = 0; i < 6; i++) {
+        if (i == 0 && !enter_userns) {
+            continue;
+        }
 		// 拼接命名空间路径
         snprintf(nspath, sizeof(nspath),
                  "/proc/%s/ns/%s", sixDocker_pid, namespaces[i]);
@@ -44,6 +130,33 @@ __attribute__((constructor)) void enter_namespace() {
         close(fd);
     }
 
+    // 宿主机 root 在容器的 user namespace 中没有映射，切换为容器内的 root
+    // rootless 模式下 user namespace 的 setgroups 被禁用，清空附加组失败时忽略
+    if (enter_userns) {
+        setgroups(0, NULL);
+        if (setgid(0) != 0 || setuid(0) != 0) {
+            fprintf(stderr, "switch to root in user namespace error: %s\n", strerror(errno));
+            exit(1);
+        }
+    }
+
+    // 进入 namespace 之后再安装 seccomp 过滤器和丢弃 capability，setns 本身需要 CAP_SYS_ADMIN
+    // 没有设置 no_new_privs 时安装过滤器同样需要 CAP_SYS_ADMIN，因此先于丢弃 capability
+    if (getenv("sixDocker_no_new_privs") && prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) != 0) {
+        fprintf(stderr, "set no_new_privs error: %s\n", strerror(errno));
+        exit(1);
+    }
+    char *sixDocker_seccomp = getenv("sixDocker_seccomp");
+    if (sixDocker_seccomp && install_seccomp(sixDocker_seccomp) != 0) {
+        fprintf(stderr, "install seccomp filter error: %s\n", strerror(errno));
+        exit(1);
+    }
+    char *sixDocker_caps = getenv("sixDocker_caps");
+    if (sixDocker_caps && drop_capabilities(strtoull(sixDocker_caps, NULL, 16)) != 0) {
+        fprintf(stderr, "drop capabilities error: %s\n", strerror(errno));
+        exit(1);
+    }
+
     int ret = system(sixDocker_cmd);
     (void)ret;
     exit(0);
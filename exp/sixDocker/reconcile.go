@@ -0,0 +1,57 @@
+// exp/sixDocker/reconcile.go
+
+package main
+
+import (
+	"fmt"
+	"sixDocker/container"
+	"sixDocker/network"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 回收宿主机重启、run 或 monitor 进程崩溃后残留的状态:
+// 记录为运行中但容器进程已经不存在(按进程号和启动时间判断)的容器标记为已退出，并回收它的挂载点、cgroup 和网络端点，
+// 然后删除容器已经不存在的网络端点、重建 IP 分配位图，最后删除指向不存在的容器的名称索引
+// sixDocker 没有常驻的 daemon，需要在宿主机重启后手动执行(或者放到开机脚本中)
+func Reconcile() error {
+	containers, err := container.LoadAllContainers()
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		containerInfo, stale, err := container.ReconcileContainer(c.Id)
+		if err != nil {
+			log.Errorf("Reconcile container %s error: %v", c.Name, err)
+			continue
+		}
+		if !stale {
+			continue
+		}
+		releaseContainerResources(containerInfo)
+		fmt.Printf("container %s marked as exited\n", containerInfo.Name)
+	}
+
+	if err := network.Init(); err != nil {
+		return err
+	}
+	endpoints, err := network.Reconcile(func(containerId string) bool {
+		resolved, err := container.ResolveContainerID(containerId)
+		return err == nil && resolved == containerId
+	})
+	if err != nil {
+		return err
+	}
+	for _, ep := range endpoints {
+		fmt.Printf("endpoint %s released\n", ep)
+	}
+
+	names, err := container.PruneContainerNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Printf("name %s released\n", name)
+	}
+	return nil
+}
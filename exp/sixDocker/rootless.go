@@ -0,0 +1,117 @@
+// exp/sixDocker/rootless.go
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sixDocker/container"
+	"strconv"
+	"syscall"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	// 已经进入 rootless 模式的 user namespace，monitor、init 等子进程会继承
+	rootlessEnv = "SIXDOCKER_ROOTLESS"
+	// 父进程写完 uid/gid 映射后关闭该文件描述符，子进程读到 EOF 后才能继续执行
+	rootlessSyncEnv = "_SIXDOCKER_ROOTLESS_SYNC_FD"
+)
+
+// 以普通用户运行时进入 rootless 模式:
+// 重新执行当前命令，新进程位于以当前用户为 root 的 user namespace 和新的 mount namespace 中，
+// overlay、卷等挂载都在这个 mount namespace 中完成，容器的其他 namespace 也都归属于这个 user namespace
+// exec 需要从宿主机的 user namespace 进入容器的 user namespace，因此不重新执行
+func setupRootless(context *cli.Context) error {
+	if fd := os.Getenv(rootlessSyncEnv); fd != "" {
+		os.Unsetenv(rootlessSyncEnv)
+		if err := waitIDMappings(fd); err != nil {
+			return err
+		}
+		// 进程在写入映射之前就已经 exec，当时的 uid 没有映射，capability 全部被清空
+		// 映射写入后再 exec 一次，以 user namespace 中 root 的身份重新获得 capability
+		return syscall.Exec("/proc/self/exe", os.Args, os.Environ())
+	}
+	container.Rootless = os.Geteuid() != 0 || os.Getenv(rootlessEnv) != ""
+	if os.Geteuid() == 0 || context.Args().First() == "exec" {
+		return nil
+	}
+
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{readPipe}
+	cmd.Env = append(os.Environ(), rootlessEnv+"=1", rootlessSyncEnv+"=3")
+	if err := cmd.Start(); err != nil {
+		readPipe.Close()
+		writePipe.Close()
+		return fmt.Errorf("create user namespace error: %v", err)
+	}
+	readPipe.Close()
+
+	uidMaps, gidMaps := container.RootlessIDMappings()
+	if err := container.WriteIDMappings(cmd.Process.Pid, uidMaps, gidMaps); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		writePipe.Close()
+		return err
+	}
+	writePipe.Close()
+
+	// 命令已经在 user namespace 中执行完毕，以它的退出码退出
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
+
+// 等待父进程写入 uid/gid 映射，之后当前进程才是 user namespace 中的 root
+func waitIDMappings(fdText string) error {
+	fd, err := strconv.Atoi(fdText)
+	if err != nil {
+		return fmt.Errorf("invalid %s %s", rootlessSyncEnv, fdText)
+	}
+	pipe := os.NewFile(uintptr(fd), "rootless-sync")
+	defer pipe.Close()
+	if _, err := ioutil.ReadAll(pipe); err != nil {
+		return err
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("uid mapping of the user namespace is not written")
+	}
+	return nil
+}
+
+// rootless 模式下默认的状态根目录和配置文件都在当前用户的目录下
+func rootlessDefaultRoot() (string, error) {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return "", fmt.Errorf("XDG_RUNTIME_DIR is not set, please specify the root directory with --root or %s", rootEnv)
+	}
+	return filepath.Join(runtimeDir, "sixDocker"), nil
+}
+
+func rootlessDefaultConfigFile() string {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		home, _ := os.UserHomeDir()
+		configDir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configDir, "sixDocker", "config.json")
+}
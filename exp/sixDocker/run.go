@@ -4,28 +4,103 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
 	"sixDocker/cgroups"
-	"sixDocker/cgroups/subsystems"
 	"sixDocker/container"
 	"sixDocker/network"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func Run(
-	resConf *subsystems.ResourceConfig, tty bool,
-	volume []string, containerName string, envSlice []string,
-	nw string, portMapping []string, imageName string, command []string) {
+func Run(spec *container.ContainerInfo, tty bool) {
+	containerInfo, err := createContainer(spec)
+	if err != nil {
+		log.Errorf("Create container error: %v", err)
+		return
+	}
+
+	// -d 模式下由 monitor 进程作为容器进程的父进程，负责等待容器退出并回收资源
+	if !tty {
+		if err := startMonitor(containerInfo); err != nil {
+			log.Errorf("Start container %s error: %v", containerInfo.Name, err)
+		}
+		return
+	}
+
+	// tty 模式下由当前进程等待容器进程结束
+	containerInfo.MonitorPid = strconv.Itoa(os.Getpid())
+	parent, err := startContainer(containerInfo, true)
+	if err == nil {
+		superviseContainer(parent, containerInfo, true)
+	} else {
+		log.Errorf("Start container %s error: %v", containerInfo.Name, err)
+		markContainerFailed(containerInfo, err)
+	}
+	// 没有指定 --rm 的容器保留为退出状态，可以继续查看日志或者 commit
+	autoRemoveContainer(containerInfo)
+	os.Exit(0)
+}
+
+// 以 --rm 启动的容器退出后删除容器的工作空间、配置目录、cgroup 和网络端点
+func autoRemoveContainer(containerInfo *container.ContainerInfo) {
+	if !containerInfo.AutoRemove {
+		return
+	}
+	log.Infof("Auto removing container %s", containerInfo.Name)
+	if err := removeContainer(containerInfo.Id); err != nil {
+		log.Errorf("Remove container %s error: %v", containerInfo.Name, err)
+	}
+}
+
+// 创建容器: 生成 config.json、挂载 ufs、为容器分配网络端点，容器处于 created 状态
+// 容器进程在 start(或 run) 时才会启动，config.json 中记录了启动容器需要的全部参数
+func createContainer(spec *container.ContainerInfo) (*container.ContainerInfo, error) {
+	containerInfo, err := container.CreateContainerInfo(spec)
+	if err != nil {
+		return nil, fmt.Errorf("create container info error: %v", err)
+	}
+
+	// 任意一步失败都删除已经创建的容器
+	fail := func(err error) (*container.ContainerInfo, error) {
+		releaseContainerResources(containerInfo)
+		if err := container.DeleteContainer(containerInfo.Id, false); err != nil {
+			log.Errorf("Delete container error: %v", err)
+		}
+		return nil, err
+	}
+
+	// ufs 创建
+	if _, err := container.NewWorkSpace(containerInfo); err != nil {
+		return fail(fmt.Errorf("new workspace error: %v", err))
+	}
+
+	// 分配网络端点
+	if containerInfo.Network != "" {
+		network.Init()
+		if _, err := network.CreateEndpoint(containerInfo.Network, containerInfo); err != nil {
+			return fail(fmt.Errorf("create network endpoint error: %v", err))
+		}
+	}
+	return containerInfo, nil
+}
+
+// 启动容器进程: 创建 ufs、启动 init 进程、设置 cgroup、连接网络，最后通过管道发送用户命令
+// 任意一步失败都会杀掉 init 进程并回收已经分配的资源
+func startContainer(containerInfo *container.ContainerInfo, tty bool) (*exec.Cmd, error) {
 	// 准备容器的根进程 使用当前可执行文件 + init 进行启动
 	// 返回父进程对象和用于和子进程通信的管道
-	parent, writePipe := container.NewParentProcess()
+	parent, writePipe := container.NewParentProcess(containerInfo)
 	if parent == nil {
-		log.Errorf("New parent process error")
-		return
+		return nil, fmt.Errorf("new parent process error")
 	}
 
 	if tty {
@@ -35,20 +110,12 @@ func Run(
 	}
 
 	// 设置环境变量
-	parent.Env = append(os.Environ(), envSlice...)
-
-	// 生成容器 config，pid 只能在 Start 之后才能获取到，因此先用 -1 占位
-	containerInfo, err := container.CreateContainerInfoByName(containerName, -1, command, volume, portMapping)
-	if err != nil {
-		log.Errorf("Create container info error: %v", err)
-		return
-	}
+	parent.Env = append(os.Environ(), containerInfo.Env...)
 
 	// ufs 创建
-	mntURL, err := container.NewWorkSpace(containerInfo.Name, imageName, volume)
+	mntURL, err := container.NewWorkSpace(containerInfo)
 	if err != nil {
-		log.Errorf("New workspace error: %v", err)
-		return
+		return nil, fmt.Errorf("new workspace error: %v", err)
 	}
 	// 设置父进程的根文件系统，init 进程会将 Dir 作为自己的根文件系统
 	parent.Dir = mntURL
@@ -56,18 +123,14 @@ func Run(
 	// logs 实现
 	// 对parent的操作需要在start之前完成，因为start之后parent的某些属性会被锁定
 	if !tty {
-		logFileDir := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Name)
-		// 先创建目录
-		if err := os.MkdirAll(logFileDir, 0755); err != nil {
-			log.Errorf("MkdirAll log file dir %s error: %v", logFileDir, err)
-			return
-		}
+		logFileDir := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Id)
 		logFilePath := path.Join(logFileDir, container.ContainerLogFile)
-		logFile, err := os.Create(logFilePath)
+		logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
-			log.Errorf("Create log file %s error: %v", logFilePath, err)
-			return
+			releaseContainerResources(containerInfo)
+			return nil, fmt.Errorf("open log file %s error: %v", logFilePath, err)
 		}
+		defer logFile.Close()
 		parent.Stdout = logFile
 		parent.Stderr = logFile
 		parent.Stdin = nil
@@ -76,71 +139,318 @@ func Run(
 	// 启动子进程 ./sixDocker
 	// 子进程会在 readUserCommand 中阻塞等待父进程通过管道发送命令(sendInitCommand(command, writePipe))
 	if err := parent.Start(); err != nil {
-		log.Error(err)
-		return
+		releaseContainerResources(containerInfo)
+		return nil, err
 	}
 
 	// 记录容器Pid（必须在Start()之后，因为 Process.Pid 只有在Start()后才可用）
 	log.Infof("Container %s PID %d", containerInfo.Name, parent.Process.Pid)
-	containerInfo.Pid = strconv.Itoa(parent.Process.Pid)
-	if err := container.UpdateContainerInfoByName(containerInfo.Name, containerInfo); err != nil {
+	if err := markContainerRunning(containerInfo, parent.Process.Pid); err != nil {
+		abortContainer(parent, containerInfo)
+		if err == errContainerStopped {
+			return nil, err
+		}
+		return nil, fmt.Errorf("update container info error: %v", err)
+	}
+
+	// rootless 模式下没有可用的 cgroup 时不限制资源
+	if containerInfo.CgroupPath != "" {
+		// 创建cgroup管理器
+		cgroupManager := cgroups.NewCgroupManager(containerInfo.CgroupPath)
+		// 设置资源限制
+		if containerInfo.ResourceConfig != nil {
+			if err := cgroupManager.Set(containerInfo.ResourceConfig); err != nil {
+				abortContainer(parent, containerInfo)
+				return nil, fmt.Errorf("set cgroup error: %v", err)
+			}
+		}
+		// 将容器进程加入到各个subsystem挂载对应的cgroup中
+		if err := cgroupManager.Apply(parent.Process.Pid); err != nil {
+			abortContainer(parent, containerInfo)
+			return nil, fmt.Errorf("apply cgroup error: %v", err)
+		}
+	}
+	// 网络设置
+	if containerInfo.Network != "" {
+		network.Init()
+		if err := network.Connect(containerInfo.Network, containerInfo); err != nil {
+			abortContainer(parent, containerInfo)
+			return nil, fmt.Errorf("connect network error: %v", err)
+		}
+		log.Infof("Connect network %s success", containerInfo.Network)
+	}
+
+	// 通过管道传递初始化容器进程要执行的命令
+	log.Infof("parent writePipe %v", writePipe)
+	// 子进程接收到数据后会从管道中读取命令并执行
+	sendInitCommand(&container.InitSpec{
+		Command:         containerInfo.CommandArray,
+		Init:            containerInfo.Init,
+		Capabilities:    containerInfo.Capabilities,
+		Seccomp:         containerInfo.SeccompProfile,
+		ReadOnly:        containerInfo.ReadOnly,
+		NoNewPrivileges: containerInfo.NoNewPrivileges,
+	}, writePipe)
+	return parent, nil
+}
+
+// 容器在启动过程中被 stop
+var errContainerStopped = errors.New("container is stopped")
+
+// 在锁内记录新启动的容器进程，并将容器标记为运行中
+// 等待重启期间容器可能被 stop，stop 在锁内设置 ManuallyStopped 后不会再看到这次启动的进程，
+// 因此这里必须在同一把锁内再检查一次，被 stop 的容器放弃启动
+func markContainerRunning(containerInfo *container.ContainerInfo, pid int) error {
+	latest, err := container.UpdateContainerInfo(containerInfo.Id, func(latest *container.ContainerInfo) error {
+		if latest.ManuallyStopped {
+			return errContainerStopped
+		}
+		latest.Pid = strconv.Itoa(pid)
+		latest.PidStartTime = container.ProcessStartTime(pid)
+		// 当前进程就是等待容器进程的进程
+		latest.MonitorPid = containerInfo.MonitorPid
+		latest.MonitorStartTime = container.ProcessStartTime(os.Getpid())
+		latest.Status = container.RUNNING
+		latest.StartedAt = time.Now().Format(container.TimeFormat)
+		// 清空上一次运行留下的退出信息
+		latest.FinishedAt = ""
+		latest.ExitCode = 0
+		latest.OOMKilled = false
+		latest.Error = ""
+		// 每个容器使用以容器 ID 命名的独立 cgroup，避免不同容器的资源限制互相覆盖
+		cgroupPath, err := container.ContainerCgroupPath(latest)
+		if err != nil {
+			return err
+		}
+		latest.CgroupPath = cgroupPath
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*containerInfo = *latest
+	return nil
+}
+
+// 容器启动失败时杀掉阻塞在管道上的 init 进程并回收资源
+func abortContainer(parent *exec.Cmd, containerInfo *container.ContainerInfo) {
+	parent.Process.Kill()
+	parent.Wait()
+	releaseContainerResources(containerInfo)
+}
+
+// 等待容器进程退出，记录退出码和退出时间，并回收容器运行时占用的资源
+func waitContainer(parent *exec.Cmd, containerInfo *container.ContainerInfo) {
+	parent.Wait()
+	exitCode := exitCodeOf(parent.ProcessState)
+	log.Infof("Container %s exited with code %d", containerInfo.Name, exitCode)
+
+	// OOM 事件记录在 cgroup 中，必须在回收 cgroup 之前读取
+	oomKilled := false
+	if containerInfo.CgroupPath != "" {
+		oomKilled = cgroups.NewCgroupManager(containerInfo.CgroupPath).OOMKilled()
+	}
+
+	releaseContainerResources(containerInfo)
+
+	// 在锁内基于最新的容器信息更新，避免覆盖其他命令在容器运行期间做的修改
+	latest, err := container.UpdateContainerInfo(containerInfo.Id, func(latest *container.ContainerInfo) error {
+		latest.Status = container.EXIT
+		latest.Pid = ""
+		latest.ExitCode = exitCode
+		latest.OOMKilled = oomKilled
+		latest.FinishedAt = time.Now().Format(container.TimeFormat)
+		// 需要重启的容器直接进入 restarting 状态，避免 wait 等命令看到短暂的 exited 状态
+		if latest.RestartPolicy.ShouldRestart(latest) {
+			latest.Status = container.RESTARTING
+			latest.RestartCount++
+		}
+		return nil
+	})
+	if err != nil {
 		log.Errorf("Update container info error: %v", err)
 		return
 	}
+	*containerInfo = *latest
+}
 
-	// 创建cgroup管理器
-	cgroupManager := cgroups.NewCgroupManager("sixDocker-cgroup")
-	// 在非tty模式下，父进程不会等待子进程结束，在父进程执行回收时，子进程可能还没有退出，导致 cgroupManager 无法回收
-	// 因此在非tty模式下，不进行资源回收
-	if tty {
-		defer cgroupManager.Destroy()
-	}
+const (
+	// 重启间隔从 100ms 开始每次翻倍，最长 1 分钟
+	restartBackoffInitial = 100 * time.Millisecond
+	restartBackoffMax     = time.Minute
+	// 容器运行超过 10 秒后退出，重启间隔重新从 100ms 开始
+	restartBackoffResetAfter = 10 * time.Second
+)
+
+// 等待容器进程退出，并按照容器的重启策略重启容器，直到不再需要重启为止
+func superviseContainer(parent *exec.Cmd, containerInfo *container.ContainerInfo, tty bool) {
+	backoff := restartBackoffInitial
+	for {
+		startedAt := time.Now()
+		waitContainer(parent, containerInfo)
+		if containerInfo.Status != container.RESTARTING {
+			return
+		}
 
-	// 设置资源限制
-	if resConf != nil {
-		if err := cgroupManager.Set(resConf); err != nil {
-			log.Errorf("Set cgroup error: %v", err)
+		if time.Since(startedAt) >= restartBackoffResetAfter {
+			backoff = restartBackoffInitial
+		}
+		log.Infof("Restarting container %s in %v (restart count %d)", containerInfo.Name, backoff, containerInfo.RestartCount)
+		if !sleepUnlessStopped(containerInfo.Id, backoff) {
+			log.Infof("Container %s is stopped, cancel restart", containerInfo.Name)
+			return
+		}
+		backoff *= 2
+		if backoff > restartBackoffMax {
+			backoff = restartBackoffMax
+		}
+
+		// 等待期间容器信息可能被修改过，重新读取
+		latest, err := container.GetContainerInfo(containerInfo.Id)
+		if err != nil {
+			log.Errorf("Get container %s info error: %v", containerInfo.Name, err)
+			return
+		}
+		*containerInfo = *latest
+		parent, err = startContainer(containerInfo, tty)
+		if err == errContainerStopped {
+			log.Infof("Container %s is stopped, cancel restart", containerInfo.Name)
+			return
+		}
+		if err != nil {
+			log.Errorf("Restart container %s error: %v", containerInfo.Name, err)
+			markContainerFailed(containerInfo, err)
 			return
 		}
 	}
-	// 将容器进程加入到各个subsystem挂载对应的cgroup中
-	if err := cgroupManager.Apply(parent.Process.Pid); err != nil {
-		log.Errorf("Apply cgroup error: %v", err)
+}
+
+// 等待重启间隔，期间容器被 stop 或者被删除则返回 false
+func sleepUnlessStopped(containerId string, d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		containerInfo, err := container.GetContainerInfo(containerId)
+		if err != nil || containerInfo.ManuallyStopped {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// 容器启动失败时将容器标记为已退出，保留容器信息和失败原因供用户查看和删除
+func markContainerFailed(containerInfo *container.ContainerInfo, startErr error) {
+	latest, err := container.UpdateContainerInfo(containerInfo.Id, func(latest *container.ContainerInfo) error {
+		latest.Status = container.EXIT
+		latest.Pid = ""
+		latest.ExitCode = -1
+		latest.Error = startErr.Error()
+		latest.FinishedAt = time.Now().Format(container.TimeFormat)
+		// 需要重启的容器直接进入 restarting 状态，避免 wait 等命令看到短暂的 exited 状态
+		if latest.RestartPolicy.ShouldRestart(latest) {
+			latest.Status = container.RESTARTING
+			latest.RestartCount++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Update container info error: %v", err)
 		return
 	}
-	// 网络设置
-	if nw != "" {
+	*containerInfo = *latest
+}
+
+// 删除容器: 回收未运行容器残留的网络端点、cgroup 和挂载点，再删除容器的可写层和配置
+func removeContainer(containerName string) error {
+	containerInfo, err := container.GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Status == container.RUNNING || containerInfo.Status == container.PAUSED {
+		return fmt.Errorf("cannot remove a running container, please stop it first")
+	}
+	releaseContainerResources(containerInfo)
+	return container.DeleteContainer(containerInfo.Id, false)
+}
+
+// 回收容器运行时占用的资源: 网络端点、cgroup 和 ufs 挂载点，容器的可写层和配置会被保留
+func releaseContainerResources(containerInfo *container.ContainerInfo) {
+	if containerInfo.Network != "" {
 		network.Init()
-		if err := network.Connect(nw, containerInfo); err != nil {
-			log.Errorf("Connect network error: %v", err)
-			return
+		if err := network.Disconnect(containerInfo.Network, containerInfo); err != nil {
+			log.Errorf("Disconnect network %s error: %v", containerInfo.Network, err)
 		}
-		log.Infof("Connect network %s success", nw)
 	}
+	if containerInfo.CgroupPath != "" {
+		cgroups.NewCgroupManager(containerInfo.CgroupPath).Destroy()
+	}
+	container.UnmountWorkSpace(containerInfo.Id, containerInfo.Volume)
+}
 
-	// 通过管道传递初始化容器进程要执行的命令
-	log.Infof("parent writePipe %v", writePipe)
-	// 子进程接收到数据后会从管道中读取命令并执行
-	sendInitCommand(command, writePipe)
+// 与 shell 一致: 被信号杀死的进程退出码为 128 + 信号值
+func exitCodeOf(state *os.ProcessState) int {
+	if state == nil {
+		return -1
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
 
-	// 此处是为了实现 -d 参数的功能
-	// -d 为真的话就不会回收资源，在当前条件下可能会导致 ufs/mnt 目录无法卸载
-	// 因为父进程退出后 子进程会被init进程收养 导致无法回收
-	// 这里简单处理为 如果是tty模式下才等待容器进程结束 并回收资源
-	if tty {
-		// 等待容器进程结束
-		parent.Wait()
-		// 删除容器
-		if err := container.DeleteContainer(containerInfo.Name, true); err != nil {
-			log.Errorf("Delete container error: %v", err)
+// 启动 monitor 进程并等待它报告容器的启动结果
+// monitor 通过 3 号文件描述符回传容器 PID，启动失败时回传错误信息
+func startMonitor(containerInfo *container.ContainerInfo) error {
+	readPipe, writePipe, err := container.NewPipe()
+	if err != nil {
+		return err
+	}
+	defer readPipe.Close()
+
+	cmd := exec.Command("/proc/self/exe", "--root", container.RootDir, "monitor", containerInfo.Id)
+	// monitor 使用新的会话，脱离当前终端，run 进程退出后继续运行
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.ExtraFiles = []*os.File{writePipe}
+
+	// monitor 自身的日志写入容器目录下的 monitor.log
+	logFilePath := path.Join(fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Id), container.MonitorLogFile)
+	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		writePipe.Close()
+		return fmt.Errorf("open monitor log file %s error: %v", logFilePath, err)
+	}
+	defer logFile.Close()
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	if err := cmd.Start(); err != nil {
+		writePipe.Close()
+		return err
+	}
+	// 关闭父进程中的写端，monitor 关闭写端后 ReadAll 才能返回
+	writePipe.Close()
+	msg, err := ioutil.ReadAll(readPipe)
+	if err != nil {
+		return err
+	}
+	monitorPid := cmd.Process.Pid
+	// monitor 不再由当前进程等待
+	cmd.Process.Release()
+
+	pid, err := strconv.Atoi(string(msg))
+	if err != nil {
+		if len(msg) == 0 {
+			return fmt.Errorf("monitor exited unexpectedly, see %s", logFilePath)
 		}
-		os.Exit(0)
+		return fmt.Errorf("%s", strings.TrimSpace(string(msg)))
 	}
+	log.Infof("Container %s PID %d, monitor PID %d", containerInfo.Name, pid, monitorPid)
+	return nil
 }
 
-func sendInitCommand(comArray []string, writePipe *os.File) {
-	// 使用 JSON 序列化，保留切片中每个元素的完整性（包括空格和引号）
-	jsonBytes, err := json.Marshal(comArray)
+func sendInitCommand(spec *container.InitSpec, writePipe *os.File) {
+	// 使用 JSON 序列化，保留命令中每个元素的完整性（包括空格和引号）
+	jsonBytes, err := json.Marshal(spec)
 	if err != nil {
 		log.Errorf("Marshal command error: %v", err)
 		return
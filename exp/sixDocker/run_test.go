@@ -0,0 +1,65 @@
+// exp/sixDocker/run_test.go
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sixDocker/container"
+	"strconv"
+	"testing"
+)
+
+// monitor 在重启间隔结束后读取了容器信息，随后 stop 设置了 ManuallyStopped，monitor 不能再把新进程记录为运行中
+func TestMarkContainerRunningAfterStop(t *testing.T) {
+	oldInfo := container.DefaultInfoLocation
+	container.DefaultInfoLocation = path.Join(t.TempDir(), "containers") + "/%s"
+	defer func() { container.DefaultInfoLocation = oldInfo }()
+
+	info := &container.ContainerInfo{Id: "abc123", Name: "web", Status: container.RESTARTING, RestartCount: 1}
+	if err := os.MkdirAll(fmt.Sprintf(container.DefaultInfoLocation, info.Id), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := container.SaveContainerInfo(info); err != nil {
+		t.Fatal(err)
+	}
+	// monitor 手中的容器信息
+	restarting, err := container.GetContainerInfo(info.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启间隔内执行了 stop
+	if _, err := container.UpdateContainerInfo(info.Id, func(latest *container.ContainerInfo) error {
+		latest.ManuallyStopped = true
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := markContainerRunning(restarting, os.Getpid()); err != errContainerStopped {
+		t.Fatalf("markContainerRunning after stop = %v, want %v", err, errContainerStopped)
+	}
+	latest, err := container.GetContainerInfo(info.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Status != container.RESTARTING || latest.Pid != "" {
+		t.Errorf("container after cancelled restart: status %s, pid %q", latest.Status, latest.Pid)
+	}
+
+	// 再次 start 清除标记后可以正常记录
+	if _, err := container.UpdateContainerInfo(info.Id, func(latest *container.ContainerInfo) error {
+		latest.ManuallyStopped = false
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := markContainerRunning(restarting, os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+	if restarting.Status != container.RUNNING || restarting.Pid != strconv.Itoa(os.Getpid()) {
+		t.Errorf("container after restart: status %s, pid %q", restarting.Status, restarting.Pid)
+	}
+}
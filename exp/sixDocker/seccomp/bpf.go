@@ -0,0 +1,303 @@
+// exp/sixDocker/seccomp/bpf.go
+
+package seccomp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// linux/seccomp.h 中的常量
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+
+	retKillProcess = 0x80000000
+	retKillThread  = 0x00000000
+	retTrap        = 0x00030000
+	retErrno       = 0x00050000
+	retTrace       = 0x7ff00000
+	retLog         = 0x7ffc0000
+	retAllow       = 0x7fff0000
+
+	// x86_64 上 x32 ABI 的系统调用编号带有该标志位
+	x32SyscallBit = 0x40000000
+)
+
+// struct seccomp_data 中各字段的偏移: int nr; __u32 arch; __u64 instruction_pointer; __u64 args[6];
+// 只支持小端架构，参数的低 32 位在前
+const (
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+)
+
+// 跳转目标: 非负数为 label 编号，负数表示相对偏移，next 即跳过 0 条指令
+const next = -1
+
+// 跳过 n 条指令
+func skip(n int) int {
+	return -n - 1
+}
+
+type instruction struct {
+	filter unix.SockFilter
+	jt, jf int
+}
+
+// 简单的 BPF 汇编器，条件跳转的目标用 label 表示，全部指令生成后再计算偏移
+type assembler struct {
+	insns  []instruction
+	labels []int
+}
+
+func (a *assembler) newLabel() int {
+	a.labels = append(a.labels, -1)
+	return len(a.labels) - 1
+}
+
+func (a *assembler) setLabel(label int) {
+	a.labels[label] = len(a.insns)
+}
+
+func (a *assembler) stmt(code uint16, k uint32) {
+	a.insns = append(a.insns, instruction{filter: unix.SockFilter{Code: code, K: k}, jt: next, jf: next})
+}
+
+func (a *assembler) jump(op uint16, k uint32, jt int, jf int) {
+	a.insns = append(a.insns, instruction{filter: unix.SockFilter{Code: unix.BPF_JMP | op | unix.BPF_K, K: k}, jt: jt, jf: jf})
+}
+
+func (a *assembler) load(offset uint32) {
+	a.stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offset)
+}
+
+func (a *assembler) ret(value uint32) {
+	a.stmt(unix.BPF_RET|unix.BPF_K, value)
+}
+
+func (a *assembler) offset(i int, target int) (uint8, error) {
+	off := -target - 1
+	if target >= 0 {
+		off = a.labels[target] - i - 1
+	}
+	if off < 0 || off > 255 {
+		return 0, fmt.Errorf("jump offset %d out of range", off)
+	}
+	return uint8(off), nil
+}
+
+func (a *assembler) assemble() ([]unix.SockFilter, error) {
+	if len(a.insns) > unix.BPF_MAXINSNS {
+		return nil, fmt.Errorf("program too long: %d instructions", len(a.insns))
+	}
+	filter := make([]unix.SockFilter, len(a.insns))
+	for i, insn := range a.insns {
+		filter[i] = insn.filter
+		var err error
+		if filter[i].Jt, err = a.offset(i, insn.jt); err != nil {
+			return nil, err
+		}
+		if filter[i].Jf, err = a.offset(i, insn.jf); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+// 当前架构是否支持 seccomp
+func Supported() bool {
+	return nativeArch != 0
+}
+
+// 把配置编译为 BPF 程序，caps 为容器保留的 capability，用于判断规则的 includes/excludes
+// 规则按照配置文件中的顺序依次匹配，与默认动作相同的规则和本机架构上不存在的系统调用会被忽略
+func Compile(profile *Profile, caps []string) ([]unix.SockFilter, error) {
+	if nativeArch == 0 {
+		return nil, fmt.Errorf("seccomp is not supported on this architecture")
+	}
+	defaultErrno := uint(unix.EPERM)
+	if profile.DefaultErrnoRet != nil {
+		defaultErrno = *profile.DefaultErrnoRet
+	}
+	defaultRet, err := actionValue(profile.DefaultAction, &defaultErrno)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &assembler{}
+	// 其他架构(例如 x86_64 上通过 int 0x80 调用的 32 位系统调用)的编号不同，直接杀掉进程
+	a.load(offsetArch)
+	a.jump(unix.BPF_JEQ, nativeArch, skip(1), next)
+	a.ret(retKillProcess)
+	a.load(offsetNr)
+	if nativeArch == unix.AUDIT_ARCH_X86_64 {
+		a.jump(unix.BPF_JGE, x32SyscallBit, next, skip(1))
+		a.ret(retKillProcess)
+	}
+
+	kernel := kernelVersion()
+	for _, syscall := range profile.Syscalls {
+		errnoRet := syscall.ErrnoRet
+		if errnoRet == nil {
+			errnoRet = &defaultErrno
+		}
+		ret, err := actionValue(syscall.Action, errnoRet)
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range syscall.Args {
+			if arg.Index > 5 {
+				return nil, fmt.Errorf("invalid argument index %d", arg.Index)
+			}
+		}
+		if ret == defaultRet || !syscall.applies(caps, kernel) {
+			continue
+		}
+		names := syscall.Names
+		if syscall.Name != "" {
+			names = append([]string{syscall.Name}, names...)
+		}
+		for _, name := range names {
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				continue
+			}
+			if err := compileRule(a, nr, syscall.Args, ret); err != nil {
+				return nil, err
+			}
+		}
+	}
+	a.ret(defaultRet)
+	return a.assemble()
+}
+
+// 系统调用编号为 nr 并且满足所有参数条件时返回 ret，否则继续匹配下一条规则(累加器中仍然是系统调用编号)
+func compileRule(a *assembler, nr uint32, args []*Arg, ret uint32) error {
+	if len(args) == 0 {
+		a.jump(unix.BPF_JEQ, nr, next, skip(1))
+		a.ret(ret)
+		return nil
+	}
+	mismatch := a.newLabel()
+	a.jump(unix.BPF_JEQ, nr, next, mismatch)
+	for _, arg := range args {
+		match := a.newLabel()
+		if err := compileArg(a, arg, match, mismatch); err != nil {
+			return err
+		}
+		a.setLabel(match)
+	}
+	a.ret(ret)
+	// 比较参数时覆盖了累加器，重新加载系统调用编号
+	a.setLabel(mismatch)
+	a.load(offsetNr)
+	return nil
+}
+
+// 64 位参数分高低两个 32 位比较，满足条件时跳转到 match，否则跳转到 mismatch
+func compileArg(a *assembler, arg *Arg, match int, mismatch int) error {
+	lo := uint32(offsetArgs + 8*arg.Index)
+	hi := lo + 4
+	value := arg.Value
+	switch arg.Op {
+	case OpEqualTo:
+		a.load(hi)
+		a.jump(unix.BPF_JEQ, uint32(value>>32), next, mismatch)
+		a.load(lo)
+		a.jump(unix.BPF_JEQ, uint32(value), match, mismatch)
+	case OpNotEqual:
+		a.load(hi)
+		a.jump(unix.BPF_JEQ, uint32(value>>32), next, match)
+		a.load(lo)
+		a.jump(unix.BPF_JEQ, uint32(value), mismatch, match)
+	case OpMaskedEqual:
+		mask, want := arg.Value, arg.ValueTwo
+		a.load(hi)
+		a.stmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, uint32(mask>>32))
+		a.jump(unix.BPF_JEQ, uint32(want>>32), next, mismatch)
+		a.load(lo)
+		a.stmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, uint32(mask))
+		a.jump(unix.BPF_JEQ, uint32(want), match, mismatch)
+	case OpGreaterThan, OpGreaterEqual:
+		op := uint16(unix.BPF_JGT)
+		if arg.Op == OpGreaterEqual {
+			op = unix.BPF_JGE
+		}
+		a.load(hi)
+		a.jump(unix.BPF_JGT, uint32(value>>32), match, next)
+		a.jump(unix.BPF_JEQ, uint32(value>>32), next, mismatch)
+		a.load(lo)
+		a.jump(op, uint32(value), match, mismatch)
+	case OpLessThan, OpLessEqual:
+		// a < b 即 !(a >= b)，a <= b 即 !(a > b)
+		op := uint16(unix.BPF_JGE)
+		if arg.Op == OpLessEqual {
+			op = unix.BPF_JGT
+		}
+		a.load(hi)
+		a.jump(unix.BPF_JGT, uint32(value>>32), mismatch, next)
+		a.jump(unix.BPF_JEQ, uint32(value>>32), next, match)
+		a.load(lo)
+		a.jump(op, uint32(value), mismatch, match)
+	default:
+		return fmt.Errorf("unknown operator %s", arg.Op)
+	}
+	return nil
+}
+
+func actionValue(action Action, errnoRet *uint) (uint32, error) {
+	switch action {
+	case ActKill, ActKillThread:
+		return retKillThread, nil
+	case ActKillProcess:
+		return retKillProcess, nil
+	case ActTrap:
+		return retTrap, nil
+	case ActErrno:
+		return retErrno | uint32(*errnoRet&0xffff), nil
+	case ActTrace:
+		return retTrace | uint32(*errnoRet&0xffff), nil
+	case ActLog:
+		return retLog, nil
+	case ActAllow:
+		return retAllow, nil
+	default:
+		return 0, fmt.Errorf("unknown action %s", action)
+	}
+}
+
+// 为当前进程的所有线程安装过滤器
+// 没有设置 no_new_privs 时需要 CAP_SYS_ADMIN，因此要在丢弃 capability 之前调用
+func Install(filter []unix.SockFilter) error {
+	if len(filter) == 0 {
+		return fmt.Errorf("empty seccomp filter")
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	// 使用 TSYNC 时，有线程无法同步过滤器会返回该线程的 id
+	tid, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("install seccomp filter error: %v", errno)
+	}
+	if tid != 0 {
+		return fmt.Errorf("install seccomp filter error: thread %d cannot be synchronized", tid)
+	}
+	return nil
+}
+
+// 把 BPF 程序编码为十六进制字符串，每条指令依次为小端的 code(2 字节)、jt、jf、k(4 字节)
+// exec 通过环境变量把它传给 nsenter 中的 C 代码
+func EncodeFilter(filter []unix.SockFilter) string {
+	buf := make([]byte, 8*len(filter))
+	for i, f := range filter {
+		binary.LittleEndian.PutUint16(buf[8*i:], f.Code)
+		buf[8*i+2] = f.Jt
+		buf[8*i+3] = f.Jf
+		binary.LittleEndian.PutUint32(buf[8*i+4:], f.K)
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,255 @@
+// exp/sixDocker/seccomp/seccomp.go
+
+package seccomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// 与 docker 兼容的 seccomp 配置文件格式
+// architectures/archMap 只为兼容 docker 的配置文件而保留，过滤器只允许本机架构的系统调用
+type Profile struct {
+	DefaultAction   Action     `json:"defaultAction"`
+	DefaultErrnoRet *uint      `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string   `json:"architectures,omitempty"`
+	ArchMap         []ArchMap  `json:"archMap,omitempty"`
+	Syscalls        []*Syscall `json:"syscalls"`
+}
+
+type ArchMap struct {
+	Architecture     string   `json:"architecture"`
+	SubArchitectures []string `json:"subArchitectures"`
+}
+
+// 一条规则: 系统调用名称在 Names(或旧格式的 Name)中并且所有 Args 条件都满足时执行 Action
+type Syscall struct {
+	Name     string   `json:"name,omitempty"`
+	Names    []string `json:"names,omitempty"`
+	Action   Action   `json:"action"`
+	Args     []*Arg   `json:"args,omitempty"`
+	ErrnoRet *uint    `json:"errnoRet,omitempty"`
+	Includes *Filter  `json:"includes,omitempty"`
+	Excludes *Filter  `json:"excludes,omitempty"`
+}
+
+// 比较第 Index 个参数，SCMP_CMP_MASKED_EQ 时 Value 为掩码，ValueTwo 为比较的值
+type Arg struct {
+	Index    uint     `json:"index"`
+	Value    uint64   `json:"value"`
+	ValueTwo uint64   `json:"valueTwo"`
+	Op       Operator `json:"op"`
+}
+
+// 规则生效的条件，caps 需要全部满足，arches 为 GOARCH 形式的架构名，minKernel 形如 5.8
+type Filter struct {
+	Caps      []string `json:"caps,omitempty"`
+	Arches    []string `json:"arches,omitempty"`
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+type Action string
+
+const (
+	ActKill        Action = "SCMP_ACT_KILL"
+	ActKillThread  Action = "SCMP_ACT_KILL_THREAD"
+	ActKillProcess Action = "SCMP_ACT_KILL_PROCESS"
+	ActTrap        Action = "SCMP_ACT_TRAP"
+	ActErrno       Action = "SCMP_ACT_ERRNO"
+	ActTrace       Action = "SCMP_ACT_TRACE"
+	ActLog         Action = "SCMP_ACT_LOG"
+	ActAllow       Action = "SCMP_ACT_ALLOW"
+)
+
+type Operator string
+
+const (
+	OpNotEqual     Operator = "SCMP_CMP_NE"
+	OpLessThan     Operator = "SCMP_CMP_LT"
+	OpLessEqual    Operator = "SCMP_CMP_LE"
+	OpEqualTo      Operator = "SCMP_CMP_EQ"
+	OpGreaterEqual Operator = "SCMP_CMP_GE"
+	OpGreaterThan  Operator = "SCMP_CMP_GT"
+	OpMaskedEqual  Operator = "SCMP_CMP_MASKED_EQ"
+)
+
+// 读取并校验 seccomp 配置文件
+func LoadProfile(file string) (*Profile, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read seccomp profile %s error: %v", file, err)
+	}
+	profile := &Profile{}
+	if err := json.Unmarshal(content, profile); err != nil {
+		return nil, fmt.Errorf("parse seccomp profile %s error: %v", file, err)
+	}
+	// 提前编译一次，尽早发现配置文件中的错误
+	if _, err := Compile(profile, nil); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile %s: %v", file, err)
+	}
+	return profile, nil
+}
+
+// 创建新的 namespace 需要的 clone 标志位:
+// CLONE_NEWNS、CLONE_NEWCGROUP、CLONE_NEWUTS、CLONE_NEWIPC、CLONE_NEWUSER、CLONE_NEWPID、CLONE_NEWNET
+var namespaceCloneFlags = []uint64{
+	unix.CLONE_NEWNS, unix.CLONE_NEWCGROUP, unix.CLONE_NEWUTS, unix.CLONE_NEWIPC,
+	unix.CLONE_NEWUSER, unix.CLONE_NEWPID, unix.CLONE_NEWNET,
+}
+
+// 默认的 seccomp 配置: 允许其他系统调用，拒绝加载内核模块、重启、kexec、操作内核密钥环、挂载文件系统等危险的系统调用
+// 与 docker 一样，容器拥有对应的 capability 时放行相应的系统调用
+func DefaultProfile() *Profile {
+	eperm := uint(unix.EPERM)
+	enosys := uint(unix.ENOSYS)
+	profile := &Profile{
+		DefaultAction:   ActAllow,
+		DefaultErrnoRet: &eperm,
+		Syscalls: []*Syscall{
+			{
+				Names: []string{
+					"acct", "add_key", "bpf", "create_module", "fsconfig", "fsmount", "fsopen", "fspick",
+					"get_kernel_syms", "kcmp", "kexec_file_load", "kexec_load", "keyctl", "lookup_dcookie",
+					"move_mount", "nfsservctl", "open_tree", "perf_event_open", "query_module", "request_key",
+					"uselib", "userfaultfd", "ustat", "vm86", "vm86old",
+				},
+				Action: ActErrno,
+			},
+			{
+				Names:    []string{"mount", "umount", "umount2", "pivot_root", "setns", "unshare", "quotactl", "swapon", "swapoff", "sysfs", "_sysctl", "name_to_handle_at"},
+				Action:   ActErrno,
+				Excludes: &Filter{Caps: []string{"CAP_SYS_ADMIN"}},
+			},
+			{
+				Names:    []string{"reboot"},
+				Action:   ActErrno,
+				Excludes: &Filter{Caps: []string{"CAP_SYS_BOOT"}},
+			},
+			{
+				Names:    []string{"init_module", "finit_module", "delete_module"},
+				Action:   ActErrno,
+				Excludes: &Filter{Caps: []string{"CAP_SYS_MODULE"}},
+			},
+			{
+				Names:    []string{"iopl", "ioperm"},
+				Action:   ActErrno,
+				Excludes: &Filter{Caps: []string{"CAP_SYS_RAWIO"}},
+			},
+			{
+				Names:    []string{"ptrace", "process_vm_readv", "process_vm_writev"},
+				Action:   ActErrno,
+				Excludes: &Filter{Caps: []string{"CAP_SYS_PTRACE"}},
+			},
+			{
+				Names:    []string{"settimeofday", "stime", "clock_settime", "clock_adjtime", "adjtimex"},
+				Action:   ActErrno,
+				Excludes: &Filter{Caps: []string{"CAP_SYS_TIME"}},
+			},
+			{
+				Names:    []string{"get_mempolicy", "set_mempolicy", "mbind", "move_pages"},
+				Action:   ActErrno,
+				Excludes: &Filter{Caps: []string{"CAP_SYS_NICE"}},
+			},
+			{
+				Names:    []string{"open_by_handle_at"},
+				Action:   ActErrno,
+				Excludes: &Filter{Caps: []string{"CAP_DAC_READ_SEARCH"}},
+			},
+			// clone3 的参数在内存中，无法检查其中的标志位，返回 ENOSYS 让 glibc 回退到 clone
+			{
+				Names:    []string{"clone3"},
+				Action:   ActErrno,
+				ErrnoRet: &enosys,
+				Excludes: &Filter{Caps: []string{"CAP_SYS_ADMIN"}},
+			},
+		},
+	}
+	// 禁止通过 clone 创建新的 namespace
+	for _, flag := range namespaceCloneFlags {
+		profile.Syscalls = append(profile.Syscalls, &Syscall{
+			Names:    []string{"clone"},
+			Action:   ActErrno,
+			Args:     []*Arg{{Index: cloneFlagsArg(), Value: flag, ValueTwo: flag, Op: OpMaskedEqual}},
+			Excludes: &Filter{Caps: []string{"CAP_SYS_ADMIN"}},
+		})
+	}
+	return profile
+}
+
+// clone 的标志位参数的位置，s390 上是第二个参数
+func cloneFlagsArg() uint {
+	if runtime.GOARCH == "s390x" {
+		return 1
+	}
+	return 0
+}
+
+// 规则是否适用于当前的容器
+func (s *Syscall) applies(caps []string, kernel [2]int) bool {
+	if s.Includes != nil {
+		for _, c := range s.Includes.Caps {
+			if !contains(caps, c) {
+				return false
+			}
+		}
+		if len(s.Includes.Arches) > 0 && !contains(s.Includes.Arches, runtime.GOARCH) {
+			return false
+		}
+		if s.Includes.MinKernel != "" && !kernelAtLeast(kernel, s.Includes.MinKernel) {
+			return false
+		}
+	}
+	if s.Excludes != nil {
+		for _, c := range s.Excludes.Caps {
+			if contains(caps, c) {
+				return false
+			}
+		}
+		if contains(s.Excludes.Arches, runtime.GOARCH) {
+			return false
+		}
+		if s.Excludes.MinKernel != "" && kernelAtLeast(kernel, s.Excludes.MinKernel) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+// 当前内核的主次版本号，读取失败时为 0.0
+func kernelVersion() [2]int {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return [2]int{}
+	}
+	return parseKernelVersion(unix.ByteSliceToString(uts.Release[:]))
+}
+
+func parseKernelVersion(release string) [2]int {
+	var version [2]int
+	parts := strings.SplitN(release, ".", 3)
+	for i := 0; i < len(parts) && i < 2; i++ {
+		digits := strings.TrimRightFunc(parts[i], func(r rune) bool { return r < '0' || r > '9' })
+		version[i], _ = strconv.Atoi(digits)
+	}
+	return version
+}
+
+func kernelAtLeast(kernel [2]int, minKernel string) bool {
+	want := parseKernelVersion(minKernel)
+	return kernel[0] > want[0] || (kernel[0] == want[0] && kernel[1] >= want[1])
+}
@@ -0,0 +1,192 @@
+// exp/sixDocker/seccomp/seccomp_test.go
+
+package seccomp
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// 解释执行 BPF 程序，只支持 Compile 生成的指令
+func run(t *testing.T, filter []unix.SockFilter, arch uint32, nr uint32, args [6]uint64) uint32 {
+	data := make([]byte, 64)
+	binary.LittleEndian.PutUint32(data[offsetNr:], nr)
+	binary.LittleEndian.PutUint32(data[offsetArch:], arch)
+	for i, arg := range args {
+		binary.LittleEndian.PutUint64(data[offsetArgs+8*i:], arg)
+	}
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		f := filter[pc]
+		switch f.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			acc = binary.LittleEndian.Uint32(data[f.K:])
+		case unix.BPF_ALU | unix.BPF_AND | unix.BPF_K:
+			acc &= f.K
+		case unix.BPF_RET | unix.BPF_K:
+			return f.K
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGT | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			var cond bool
+			switch f.Code &^ (unix.BPF_JMP | unix.BPF_K) {
+			case unix.BPF_JEQ:
+				cond = acc == f.K
+			case unix.BPF_JGT:
+				cond = acc > f.K
+			case unix.BPF_JGE:
+				cond = acc >= f.K
+			}
+			if cond {
+				pc += int(f.Jt)
+			} else {
+				pc += int(f.Jf)
+			}
+		default:
+			t.Fatalf("unknown instruction %#x", f.Code)
+		}
+	}
+	t.Fatalf("program does not return")
+	return 0
+}
+
+func TestDefaultProfile(t *testing.T) {
+	filter, err := Compile(DefaultProfile(), []string{"CAP_CHOWN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eperm := uint32(retErrno | uint32(unix.EPERM))
+	cases := []struct {
+		name  string
+		flags uint64
+		want  uint32
+	}{
+		{"getpid", 0, retAllow},
+		{"kexec_load", 0, eperm},
+		{"mount", 0, eperm},
+		{"reboot", 0, eperm},
+		{"keyctl", 0, eperm},
+		{"clone3", 0, retErrno | uint32(unix.ENOSYS)},
+		{"clone", uint64(unix.SIGCHLD), retAllow},
+		{"clone", unix.CLONE_NEWUSER | uint64(unix.SIGCHLD), eperm},
+		{"clone", unix.CLONE_NEWNET, eperm},
+	}
+	for _, c := range cases {
+		nr, ok := syscallNumbers[c.name]
+		if !ok {
+			continue
+		}
+		if got := run(t, filter, nativeArch, nr, [6]uint64{c.flags}); got != c.want {
+			t.Errorf("%s(%#x) = %#x, want %#x", c.name, c.flags, got, c.want)
+		}
+	}
+	if got := run(t, filter, nativeArch+1, 0, [6]uint64{}); got != retKillProcess {
+		t.Errorf("foreign arch = %#x, want kill", got)
+	}
+
+	// 拥有 CAP_SYS_ADMIN 时允许 mount 和创建 namespace
+	filter, err = Compile(DefaultProfile(), []string{"CAP_SYS_ADMIN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"mount", "clone"} {
+		if nr, ok := syscallNumbers[name]; ok {
+			if got := run(t, filter, nativeArch, nr, [6]uint64{unix.CLONE_NEWUSER}); got != retAllow {
+				t.Errorf("%s with CAP_SYS_ADMIN = %#x, want allow", name, got)
+			}
+		}
+	}
+}
+
+func TestCompareOperators(t *testing.T) {
+	nr := syscallNumbers["getpid"]
+	const big = 0x100000005
+	cases := []struct {
+		op     Operator
+		values map[uint64]bool
+	}{
+		{OpEqualTo, map[uint64]bool{big: true, 5: false, big + 1: false}},
+		{OpNotEqual, map[uint64]bool{big: false, 5: true, 0x200000005: true}},
+		{OpGreaterThan, map[uint64]bool{big: false, big + 1: true, 0x200000000: true, 0xffffffff: false}},
+		{OpGreaterEqual, map[uint64]bool{big: true, big - 1: false, 0x200000000: true}},
+		{OpLessThan, map[uint64]bool{big: false, big - 1: true, 0xffffffff: true, 0x200000000: false}},
+		{OpLessEqual, map[uint64]bool{big: true, big + 1: false, 6: true}},
+	}
+	for _, c := range cases {
+		profile := &Profile{
+			DefaultAction: ActAllow,
+			Syscalls: []*Syscall{{
+				Names:  []string{"getpid"},
+				Action: ActKillProcess,
+				Args:   []*Arg{{Index: 2, Value: big, Op: c.op}},
+			}},
+		}
+		filter, err := Compile(profile, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for value, match := range c.values {
+			want := uint32(retAllow)
+			if match {
+				want = retKillProcess
+			}
+			if got := run(t, filter, nativeArch, nr, [6]uint64{0, 0, value}); got != want {
+				t.Errorf("%s %#x: got %#x, want %#x", c.op, value, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "profile.json")
+	os.WriteFile(file, []byte(`{
+		"defaultAction": "SCMP_ACT_ERRNO",
+		"defaultErrnoRet": 38,
+		"architectures": ["SCMP_ARCH_X86_64", "SCMP_ARCH_AARCH64"],
+		"syscalls": [
+			{"names": ["read", "write", "exit_group", "no_such_syscall"], "action": "SCMP_ACT_ALLOW"},
+			{"names": ["personality"], "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "value": 8, "op": "SCMP_CMP_EQ"}]},
+			{"names": ["ptrace"], "action": "SCMP_ACT_ALLOW", "includes": {"caps": ["CAP_SYS_PTRACE"]}}
+		]
+	}`), 0644)
+	profile, err := LoadProfile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := Compile(profile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enosys := uint32(retErrno | uint32(unix.ENOSYS))
+	cases := []struct {
+		name string
+		arg  uint64
+		want uint32
+	}{
+		{"write", 0, retAllow},
+		{"getpid", 0, enosys},
+		{"personality", 8, retAllow},
+		{"personality", 0xffffffff, enosys},
+		{"ptrace", 0, enosys},
+	}
+	for _, c := range cases {
+		if got := run(t, filter, nativeArch, syscallNumbers[c.name], [6]uint64{c.arg}); got != c.want {
+			t.Errorf("%s(%#x) = %#x, want %#x", c.name, c.arg, got, c.want)
+		}
+	}
+
+	os.WriteFile(file, []byte(`{"defaultAction": "SCMP_ACT_NOPE", "syscalls": []}`), 0644)
+	if _, err := LoadProfile(file); err == nil {
+		t.Errorf("expected error for unknown action")
+	}
+}
+
+func TestEncodeFilter(t *testing.T) {
+	filter := []unix.SockFilter{{Code: 0x20, Jt: 1, Jf: 2, K: 0xc000003e}}
+	if got, want := EncodeFilter(filter), "200001023e0000c0"; got != want {
+		t.Errorf("EncodeFilter = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,11 @@
+// exp/sixDocker/seccomp/syscalls_other.go
+
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package seccomp
+
+// 其他架构暂不支持 seccomp，Supported 返回 false，Compile 会返回错误
+const nativeArch = 0
+
+var syscallNumbers = map[string]uint32{}